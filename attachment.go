@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+)
+
+// colors of the attachment by severity, as named by Slack
+const (
+	colorExpired = "danger"
+	colorNear    = "warning"
+	colorClear   = "good"
+)
+
+// slackAttachment is a legacy attachment of a message.
+type slackAttachment struct {
+	Color    string   `json:"color"`
+	Text     string   `json:"text"`
+	Fallback string   `json:"fallback"`
+	MrkdwnIn []string `json:"mrkdwn_in"`
+}
+
+// newAttachment builds the attachment of the report,
+// colored red when there are expired issues, yellow when near ones only, or green.
+func newAttachment(rep report) slackAttachment {
+	color := colorClear
+	switch {
+	case rep.Expired > 0:
+		color = colorExpired
+	case rep.Near > 0:
+		color = colorNear
+	}
+	return slackAttachment{
+		Color:    color,
+		Text:     rep.Text,
+		Fallback: rep.Text,
+		MrkdwnIn: []string{"text"},
+	}
+}
+
+// PostAttachment posts a message of the attachment using chat.postMessage.
+func (p *slackPoster) PostAttachment(ctx context.Context, channel string, attachment slackAttachment) (postedMessage, error) {
+	b, err := json.Marshal([]slackAttachment{attachment})
+	if err != nil {
+		return postedMessage{}, err
+	}
+	params := url.Values{}
+	params.Set("channel", channel)
+	params.Set("attachments", string(b))
+	params.Set("link_names", "true")
+	var res struct {
+		Channel string `json:"channel"`
+		TS      string `json:"ts"`
+	}
+	if err := p.call(ctx, "chat.postMessage", params, &res); err != nil {
+		return postedMessage{}, err
+	}
+	return postedMessage{Channel: res.Channel, TS: res.TS}, nil
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// limits of Block Kit
+const (
+	// maxSectionText is the maximum length of the text of a section block.
+	maxSectionText = 3000
+	// maxBlocks is the maximum number of blocks in a message.
+	maxBlocks = 50
+)
+
+// slackBlock is a layout block of Block Kit.
+// lestrrat-go/slack does not support blocks, so they are built here.
+type slackBlock struct {
+	Type string           `json:"type"`
+	Text *slackTextObject `json:"text,omitempty"`
+	// Elements is the elements of a context block.
+	Elements []slackTextObject `json:"elements,omitempty"`
+}
+
+type slackTextObject struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+// newBlocks splits the report text at the breaks into categories,
+// and builds section blocks for each category with dividers between them.
+func newBlocks(text string, breaks []int) []slackBlock {
+	var blocks []slackBlock
+	var start int
+	for _, end := range append(breaks, len(text)) {
+		category := strings.TrimSpace(text[start:end])
+		start = end
+		if category == "" {
+			continue
+		}
+		if len(blocks) > 0 {
+			blocks = append(blocks, slackBlock{Type: "divider"})
+		}
+		blocks = append(blocks, newSectionBlocks(category)...)
+	}
+	return blocks
+}
+
+// newSectionBlocks builds section blocks of the text,
+// split by lines so that each text is not longer than maxSectionText.
+func newSectionBlocks(text string) []slackBlock {
+	var blocks []slackBlock
+	var section string
+	for _, line := range strings.SplitAfter(text, "\n") {
+		if len(section)+len(line) > maxSectionText && section != "" {
+			blocks = append(blocks, newSectionBlock(section))
+			section = ""
+		}
+		section += line
+	}
+	if section != "" {
+		blocks = append(blocks, newSectionBlock(section))
+	}
+	return blocks
+}
+
+// newRichBlocks builds blocks of the report where each expired and near issue is its own section,
+// followed by a context colored by days overdue.
+// Reports which are not split into the sections, or too large for a message,
+// fall back to newBlocks.
+func newRichBlocks(opts options, rs renderState, rep report, expired, near []issue) []slackBlock {
+	if len(rep.Breaks) < 2 || len(rep.SectionHeads) < 2 {
+		return newBlocks(rep.Text, rep.Breaks)
+	}
+	var blocks []slackBlock
+	addSection := func(head string, iss []issue, rs renderState, context func(issue) string) {
+		if strings.TrimSpace(head) == "" && len(iss) == 0 {
+			return
+		}
+		if len(blocks) > 0 {
+			blocks = append(blocks, slackBlock{Type: "divider"})
+		}
+		if strings.TrimSpace(head) != "" {
+			blocks = append(blocks, newSectionBlock(head))
+		}
+		for i, is := range iss {
+			if opts.Slack.Limit > 0 && i >= opts.Slack.Limit {
+				blocks = append(blocks, newContextBlock(fmt.Sprintf(messages.LimitNote, len(iss)-opts.Slack.Limit)))
+				return
+			}
+			var line bytes.Buffer
+			writeIssue(&line, opts, rs, is)
+			blocks = append(blocks, newSectionBlock(line.String()), newContextBlock(context(is)))
+		}
+	}
+	addSection(rep.SectionHeads[0], expired, rs, func(is issue) string {
+		n := daysOverdue(is, rs.Today)
+		return overdueEmoji(n) + " " + fmt.Sprintf(messages.DaysOverdue, n)
+	})
+	nearRS := rs
+	nearRS.NoMention = rs.NoMention || opts.Slack.MentionExpiredOnly
+	addSection(rep.SectionHeads[1], near, nearRS, func(is issue) string {
+		return "🟢 " + fmt.Sprintf(messages.DaysRemaining, daysRemaining(is, rs.Today))
+	})
+	if rest := strings.TrimSpace(rep.Text[rep.Breaks[1]:]); rest != "" {
+		blocks = append(blocks, slackBlock{Type: "divider"})
+		blocks = append(blocks, newSectionBlocks(rest)...)
+	}
+	if len(blocks) > maxBlocks {
+		warnf("too many issues to post each as a section, fall back to post the sections")
+		return newBlocks(rep.Text, rep.Breaks)
+	}
+	return blocks
+}
+
+// overdueEmoji returns the emoji colored by days overdue,
+// yellow, orange and red at --rich-overdue-days.
+func overdueEmoji(days int) string {
+	switch {
+	case days < richOverdueDays[0]:
+		return "🟡"
+	case days < richOverdueDays[1]:
+		return "🟠"
+	}
+	return "🔴"
+}
+
+func newContextBlock(text string) slackBlock {
+	return slackBlock{
+		Type:     "context",
+		Elements: []slackTextObject{{Type: "mrkdwn", Text: text}},
+	}
+}
+
+func newSectionBlock(text string) slackBlock {
+	return slackBlock{
+		Type: "section",
+		Text: &slackTextObject{Type: "mrkdwn", Text: text},
+	}
+}
+
+// PostBlocks posts a message of the blocks using chat.postMessage.
+// The text is used as the fallback for notifications.
+func (p *slackPoster) PostBlocks(ctx context.Context, channel, text string, blocks []slackBlock) (postedMessage, error) {
+	b, err := json.Marshal(blocks)
+	if err != nil {
+		return postedMessage{}, err
+	}
+	params := url.Values{}
+	params.Set("channel", channel)
+	params.Set("text", text)
+	params.Set("blocks", string(b))
+	params.Set("link_names", "true")
+	var res struct {
+		Channel string `json:"channel"`
+		TS      string `json:"ts"`
+	}
+	if err := p.call(ctx, "chat.postMessage", params, &res); err != nil {
+		return postedMessage{}, err
+	}
+	return postedMessage{Channel: res.Channel, TS: res.TS}, nil
+}
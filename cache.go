@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	redmine "github.com/mattn/go-redmine"
+)
+
+// redmineCache is the users and projects of a Redmine, cached to disk across runs.
+type redmineCache struct {
+	Endpoint         string            `json:"endpoint"`
+	UsersCachedAt    time.Time         `json:"users_cached_at"`
+	Users            []redmine.User    `json:"users"`
+	ProjectsCachedAt time.Time         `json:"projects_cached_at"`
+	Projects         []redmine.Project `json:"projects"`
+}
+
+// cacheMu guards the cache file, which is updated by concurrent loaders.
+var cacheMu sync.Mutex
+
+// cachePath returns the path of the cache file, keyed by the endpoint
+// so that the caches of different Redmines are not mixed.
+func cachePath(opts redmineOptions) (string, error) {
+	dir := opts.CacheDir
+	if dir == "" {
+		userDir, err := os.UserCacheDir()
+		if err != nil {
+			return "", err
+		}
+		dir = filepath.Join(userDir, "redmine-issue-summary")
+	}
+	return filepath.Join(dir, fmt.Sprintf("%x.json", sha1.Sum([]byte(opts.Endpoint)))), nil
+}
+
+// readCache reads the cache file.
+// An empty cache is returned when the cache is disabled or cannot be read.
+func readCache(opts redmineOptions) redmineCache {
+	cacheMu.Lock()
+	defer cacheMu.Unlock()
+	return readCacheFile(opts)
+}
+
+func readCacheFile(opts redmineOptions) redmineCache {
+	c := redmineCache{Endpoint: opts.Endpoint}
+	if opts.NoCache {
+		return c
+	}
+	path, err := cachePath(opts)
+	if err != nil {
+		warnf("failed to read cache: %s", err)
+		return c
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			warnf("failed to read cache: %s", err)
+		}
+		return c
+	}
+	defer f.Close()
+	if err := json.NewDecoder(f).Decode(&c); err != nil {
+		warnf("failed to read cache: %s", err)
+		return redmineCache{Endpoint: opts.Endpoint}
+	}
+	return c
+}
+
+// updateCache rewrites the cache file with the update applied.
+// Failures are only logged as the cache is optional.
+func updateCache(opts redmineOptions, update func(*redmineCache)) {
+	if opts.NoCache {
+		return
+	}
+	cacheMu.Lock()
+	defer cacheMu.Unlock()
+	c := readCacheFile(opts)
+	update(&c)
+	path, err := cachePath(opts)
+	if err != nil {
+		warnf("failed to write cache: %s", err)
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		warnf("failed to write cache: %s", err)
+		return
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		warnf("failed to write cache: %s", err)
+		return
+	}
+	defer f.Close()
+	if err := json.NewEncoder(f).Encode(c); err != nil {
+		warnf("failed to write cache: %s", err)
+	}
+}
+
+func isFresh(cachedAt time.Time, ttl time.Duration) bool {
+	return clock().Sub(cachedAt) < ttl
+}
+
+// getRedmineUsers returns the users of Redmine, from the cache if it is fresh.
+func getRedmineUsers(ctx context.Context, fetcher issueFetcher, opts redmineOptions) ([]redmine.User, error) {
+	if c := readCache(opts); isFresh(c.UsersCachedAt, opts.CacheTTL) {
+		infof("use cached redmine users")
+		return c.Users, nil
+	}
+	users, err := fetcher.FetchUsers(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	updateCache(opts, func(c *redmineCache) {
+		c.UsersCachedAt = clock()
+		c.Users = users
+	})
+	return users, nil
+}
+
+// getRedmineProjects returns the projects of Redmine, from the cache if it is fresh.
+func getRedmineProjects(ctx context.Context, fetcher issueFetcher, opts redmineOptions) ([]redmine.Project, error) {
+	if c := readCache(opts); isFresh(c.ProjectsCachedAt, opts.CacheTTL) {
+		infof("use cached redmine projects")
+		return c.Projects, nil
+	}
+	projects, err := fetcher.FetchProjects(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	updateCache(opts, func(c *redmineCache) {
+		c.ProjectsCachedAt = clock()
+		c.Projects = projects
+	})
+	return projects, nil
+}
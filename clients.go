@@ -0,0 +1,380 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lestrrat-go/slack"
+	redmine "github.com/mattn/go-redmine"
+)
+
+// issueFetcher fetches the issues to be reported and the resources to render them from Redmine.
+type issueFetcher interface {
+	FetchIssues(ctx context.Context, opts redmineOptions) ([]redmineIssue, error)
+	FetchProjects(ctx context.Context, opts redmineOptions) ([]redmine.Project, error)
+	FetchUsers(ctx context.Context, opts redmineOptions) ([]redmine.User, error)
+	FetchGroups(ctx context.Context, opts redmineOptions) ([]redmine.IdName, error)
+	FetchGroupMembers(ctx context.Context, opts redmineOptions, id int) ([]redmine.IdName, error)
+	FetchStatuses(ctx context.Context, opts redmineOptions) ([]redmine.IssueStatus, error)
+	FetchPriorities(ctx context.Context, opts redmineOptions) ([]redmine.IdName, error)
+}
+
+// messagePoster posts the report to Slack.
+type messagePoster interface {
+	Test(ctx context.Context) error
+	PostMessage(ctx context.Context, channel, text string, asUser bool) (postedMessage, error)
+	// PostInThread posts the message as a reply in the thread when threadTS is not empty.
+	PostInThread(ctx context.Context, channel, text, threadTS string) (postedMessage, error)
+	PostBlocks(ctx context.Context, channel, text string, blocks []slackBlock) (postedMessage, error)
+	PostAttachment(ctx context.Context, channel string, attachment slackAttachment) (postedMessage, error)
+	// ScheduleMessage returns the scheduled_message_id.
+	ScheduleMessage(ctx context.Context, channel, text string, postAt time.Time) (string, error)
+	DeleteMessage(ctx context.Context, pm postedMessage) error
+	ListUsers(ctx context.Context) ([]slackMember, error)
+	// ListChannels lists the channels of the types, such as "public_channel,private_channel".
+	ListChannels(ctx context.Context, types string) ([]slackChannel, error)
+	JoinChannel(ctx context.Context, id string) error
+	// PostWebhook posts the payload as JSON to the webhook URL.
+	PostWebhook(ctx context.Context, webhookURL string, payload interface{}) error
+}
+
+// redmineFetcher is the issueFetcher by Redmine API.
+type redmineFetcher struct {
+	cli *redmine.Client
+	// allStatuses fetches issues in closed statuses too, which Redmine omits by default.
+	allStatuses bool
+}
+
+func newRedmineFetcher(opts redmineOptions, allStatuses bool) redmineFetcher {
+	return redmineFetcher{cli: newRedmineClient(opts), allStatuses: allStatuses}
+}
+
+func (f redmineFetcher) FetchIssues(ctx context.Context, opts redmineOptions) ([]redmineIssue, error) {
+	params := url.Values{}
+	if f.allStatuses {
+		params.Set("status_id", "*")
+	}
+	ris, err := fetchIssues(ctx, opts, params)
+	if err != nil || f.allStatuses || closedSince == "" {
+		return ris, err
+	}
+	// only closed issues updated since the last snapshot are fetched, not all of them on every run
+	params = url.Values{}
+	params.Set("status_id", "c")
+	params.Set("updated_on", ">="+closedSince)
+	closed, err := fetchIssues(ctx, opts, params)
+	if err != nil {
+		return nil, err
+	}
+	return append(ris, closed...), nil
+}
+
+// fetchIssues fetches issues in the scope.
+func fetchIssues(ctx context.Context, opts redmineOptions, params url.Values) ([]redmineIssue, error) {
+	switch opts.Scope {
+	case scopeWatched:
+		return getWatchedIssues(ctx, opts, params)
+	case scopeMine:
+		params.Set("assigned_to_id", "me")
+		return getIssuesByQuery(ctx, opts, params)
+	}
+	return getProjectIssues(ctx, opts, params)
+}
+
+func (f redmineFetcher) FetchProjects(ctx context.Context, opts redmineOptions) ([]redmine.Project, error) {
+	var projects []redmine.Project
+	err := withRetry(ctx, func() (err error) {
+		projects, err = f.cli.Projects()
+		return err
+	}, retryAttempts, retryBackoff)
+	return projects, err
+}
+
+// FetchUsers fetches all pages of the users,
+// as go-redmine fetches only the first page.
+func (f redmineFetcher) FetchUsers(ctx context.Context, opts redmineOptions) ([]redmine.User, error) {
+	var users []redmine.User
+	params := url.Values{}
+	params.Set("limit", strconv.Itoa(maxLimit))
+	for {
+		params.Set("offset", strconv.Itoa(len(users)))
+		var res struct {
+			Users      []redmine.User `json:"users"`
+			TotalCount int            `json:"total_count"`
+		}
+		if err := getRedmine(ctx, opts, "/users.json", params, &res); err != nil {
+			return nil, err
+		}
+		users = append(users, res.Users...)
+		if len(res.Users) == 0 || len(users) >= res.TotalCount {
+			return users, nil
+		}
+	}
+}
+
+// FetchGroups fetches groups, which issues can be assigned to like users.
+// mattn/go-redmine does not support groups, so this calls Redmine's API directly.
+func (f redmineFetcher) FetchGroups(ctx context.Context, opts redmineOptions) ([]redmine.IdName, error) {
+	var res struct {
+		Groups []redmine.IdName `json:"groups"`
+	}
+	if err := getRedmine(ctx, opts, "/groups.json", url.Values{}, &res); err != nil {
+		return nil, err
+	}
+	return res.Groups, nil
+}
+
+func (f redmineFetcher) FetchGroupMembers(ctx context.Context, opts redmineOptions, id int) ([]redmine.IdName, error) {
+	var res struct {
+		Group struct {
+			Users []redmine.IdName `json:"users"`
+		} `json:"group"`
+	}
+	params := url.Values{}
+	params.Set("include", "users")
+	if err := getRedmine(ctx, opts, fmt.Sprintf("/groups/%d.json", id), params, &res); err != nil {
+		return nil, err
+	}
+	return res.Group.Users, nil
+}
+
+func (f redmineFetcher) FetchStatuses(ctx context.Context, opts redmineOptions) ([]redmine.IssueStatus, error) {
+	var statuses []redmine.IssueStatus
+	err := withRetry(ctx, func() (err error) {
+		statuses, err = f.cli.IssueStatuses()
+		return err
+	}, retryAttempts, retryBackoff)
+	return statuses, err
+}
+
+// FetchPriorities fetches the priorities in the order on Redmine.
+func (f redmineFetcher) FetchPriorities(ctx context.Context, opts redmineOptions) ([]redmine.IdName, error) {
+	var res struct {
+		Priorities []redmine.IdName `json:"issue_priorities"`
+	}
+	if err := getRedmine(ctx, opts, "/enumerations/issue_priorities.json", url.Values{}, &res); err != nil {
+		return nil, err
+	}
+	return res.Priorities, nil
+}
+
+// newRedmineClient returns the client of Redmine through the transport configured by the options.
+// The API key is sent by the transport, as go-redmine puts it in the URL.
+func newRedmineClient(opts redmineOptions) *redmine.Client {
+	cli := redmine.NewClient(opts.Endpoint, "")
+	cli.Limit = maxLimit
+	cli.Client = newRedmineHTTPClient(opts)
+	return cli
+}
+
+// newRedmineHTTPClient returns the HTTP client to call Redmine,
+// which sends the API key, the credentials of basic auth and the header given by the options.
+func newRedmineHTTPClient(opts redmineOptions) *http.Client {
+	if opts.APIKey == "" && opts.BasicAuthUser == "" && opts.Header == "" {
+		return http.DefaultClient
+	}
+	// the header is validated on parsing flags
+	name, value, _ := parseHeader(opts.Header)
+	return &http.Client{Transport: &redmineTransport{
+		base:     http.DefaultTransport,
+		apiKey:   opts.APIKey,
+		user:     opts.BasicAuthUser,
+		password: opts.BasicAuthPassword,
+		header:   name,
+		value:    value,
+	}}
+}
+
+// redmineTransport adds the API key and the credentials of the proxy in front of Redmine to requests.
+// The API key is sent by X-Redmine-API-Key header instead of the query,
+// as errors of requests include their URL, which may be logged or posted.
+type redmineTransport struct {
+	base           http.RoundTripper
+	apiKey         string
+	user, password string
+	header, value  string
+}
+
+func (t *redmineTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// RoundTripper must not modify the given request
+	req = req.Clone(req.Context())
+	if t.apiKey != "" {
+		req.Header.Set("X-Redmine-API-Key", t.apiKey)
+	}
+	if t.user != "" {
+		req.SetBasicAuth(t.user, t.password)
+	}
+	if t.header != "" {
+		req.Header.Set(t.header, t.value)
+	}
+	return t.base.RoundTrip(req)
+}
+
+// parseHeader parses the header in "Name: value" format.
+// Empty string is parsed as no header.
+func parseHeader(s string) (name, value string, err error) {
+	if s == "" {
+		return "", "", nil
+	}
+	kv := strings.SplitN(s, ":", 2)
+	if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" {
+		return "", "", fmt.Errorf("invalid redmine header: %s", s)
+	}
+	return strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1]), nil
+}
+
+// slackPoster is the messagePoster by lestrrat-go/slack,
+// calling Slack Web API directly for methods it does not support.
+type slackPoster struct {
+	cli      *slack.Client
+	token    string
+	endpoint string
+}
+
+func newSlackPoster(token string) *slackPoster {
+	return &slackPoster{cli: slack.New(token), token: token, endpoint: slackAPIEndpoint}
+}
+
+func (p *slackPoster) Test(ctx context.Context) error {
+	_, err := p.cli.Auth().Test().Do(ctx)
+	return err
+}
+
+func (p *slackPoster) PostMessage(ctx context.Context, channel, text string, asUser bool) (postedMessage, error) {
+	call := p.cli.Chat().PostMessage(channel).LinkNames(true).Text(text)
+	if asUser {
+		call = call.AsUser(true)
+	}
+	res, err := call.Do(ctx)
+	if err != nil {
+		return postedMessage{}, err
+	}
+	return postedMessage{Channel: res.Channel, TS: res.Timestamp}, nil
+}
+
+func (p *slackPoster) PostInThread(ctx context.Context, channel, text, threadTS string) (postedMessage, error) {
+	params := url.Values{}
+	params.Set("channel", channel)
+	params.Set("text", text)
+	params.Set("link_names", "true")
+	if threadTS != "" {
+		params.Set("thread_ts", threadTS)
+	}
+	var res struct {
+		Channel string `json:"channel"`
+		TS      string `json:"ts"`
+	}
+	if err := p.call(ctx, "chat.postMessage", params, &res); err != nil {
+		return postedMessage{}, err
+	}
+	return postedMessage{Channel: res.Channel, TS: res.TS}, nil
+}
+
+func (p *slackPoster) ScheduleMessage(ctx context.Context, channel, text string, postAt time.Time) (string, error) {
+	params := url.Values{}
+	params.Set("channel", channel)
+	params.Set("text", text)
+	params.Set("post_at", strconv.FormatInt(postAt.Unix(), 10))
+	params.Set("link_names", "true")
+	var res struct {
+		ScheduledMessageID string `json:"scheduled_message_id"`
+	}
+	if err := p.call(ctx, "chat.scheduleMessage", params, &res); err != nil {
+		return "", err
+	}
+	return res.ScheduledMessageID, nil
+}
+
+// ListUsers lists all users of the workspace, following the cursor of pages.
+// Each page is retried on rate limiting, waiting as Slack tells by Retry-After.
+func (p *slackPoster) ListUsers(ctx context.Context) ([]slackMember, error) {
+	var users []slackMember
+	params := url.Values{}
+	params.Set("limit", strconv.Itoa(slackUsersPageSize))
+	for {
+		var res struct {
+			Members  []slackMember `json:"members"`
+			Metadata struct {
+				NextCursor string `json:"next_cursor"`
+			} `json:"response_metadata"`
+		}
+		if err := p.call(ctx, "users.list", params, &res); err != nil {
+			return nil, err
+		}
+		users = append(users, res.Members...)
+		if res.Metadata.NextCursor == "" {
+			return users, nil
+		}
+		params.Set("cursor", res.Metadata.NextCursor)
+	}
+}
+
+// slackChannel is a channel listed by conversations.list.
+type slackChannel struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	IsPrivate bool   `json:"is_private"`
+	IsMember  bool   `json:"is_member"`
+}
+
+// ListChannels lists the channels not archived, following the cursor of pages.
+func (p *slackPoster) ListChannels(ctx context.Context, types string) ([]slackChannel, error) {
+	var channels []slackChannel
+	params := url.Values{}
+	params.Set("types", types)
+	params.Set("exclude_archived", "true")
+	params.Set("limit", "200")
+	for {
+		var res struct {
+			Channels         []slackChannel `json:"channels"`
+			ResponseMetadata struct {
+				NextCursor string `json:"next_cursor"`
+			} `json:"response_metadata"`
+		}
+		if err := p.call(ctx, "conversations.list", params, &res); err != nil {
+			return nil, err
+		}
+		channels = append(channels, res.Channels...)
+		if res.ResponseMetadata.NextCursor == "" {
+			return channels, nil
+		}
+		params.Set("cursor", res.ResponseMetadata.NextCursor)
+	}
+}
+
+func (p *slackPoster) JoinChannel(ctx context.Context, id string) error {
+	params := url.Values{}
+	params.Set("channel", id)
+	return p.call(ctx, "conversations.join", params, nil)
+}
+
+// PostWebhook posts the payload to the webhook URL,
+// which is of the incoming webhook or the Workflow Builder.
+func (p *slackPoster) PostWebhook(ctx context.Context, webhookURL string, payload interface{}) error {
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, webhookURL, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return errors.New(resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,477 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	redmine "github.com/mattn/go-redmine"
+)
+
+// fakeFetcher is the issueFetcher serving the fixed resources instead of Redmine.
+type fakeFetcher struct {
+	issues     []redmineIssue
+	projects   []redmine.Project
+	users      []redmine.User
+	groups     []redmine.IdName
+	members    map[int][]redmine.IdName
+	statuses   []redmine.IssueStatus
+	priorities []redmine.IdName
+}
+
+func (f *fakeFetcher) FetchIssues(ctx context.Context, opts redmineOptions) ([]redmineIssue, error) {
+	return f.issues, nil
+}
+
+func (f *fakeFetcher) FetchProjects(ctx context.Context, opts redmineOptions) ([]redmine.Project, error) {
+	return f.projects, nil
+}
+
+func (f *fakeFetcher) FetchUsers(ctx context.Context, opts redmineOptions) ([]redmine.User, error) {
+	return f.users, nil
+}
+
+func (f *fakeFetcher) FetchGroups(ctx context.Context, opts redmineOptions) ([]redmine.IdName, error) {
+	return f.groups, nil
+}
+
+func (f *fakeFetcher) FetchGroupMembers(ctx context.Context, opts redmineOptions, id int) ([]redmine.IdName, error) {
+	return f.members[id], nil
+}
+
+func (f *fakeFetcher) FetchStatuses(ctx context.Context, opts redmineOptions) ([]redmine.IssueStatus, error) {
+	return f.statuses, nil
+}
+
+func (f *fakeFetcher) FetchPriorities(ctx context.Context, opts redmineOptions) ([]redmine.IdName, error) {
+	return f.priorities, nil
+}
+
+// fakePoster is the messagePoster recording the calls instead of posting to Slack.
+type fakePoster struct {
+	users    []slackMember
+	channels []slackChannel
+	calls    strings.Builder
+	posts    int
+}
+
+func (p *fakePoster) record(call string, body interface{}) {
+	fmt.Fprintf(&p.calls, "== %s\n", call)
+	if s, ok := body.(string); ok {
+		p.calls.WriteString(s)
+		return
+	}
+	enc := json.NewEncoder(&p.calls)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	enc.Encode(body)
+}
+
+func (p *fakePoster) posted(channel string) postedMessage {
+	p.posts++
+	return postedMessage{Channel: channel, TS: fmt.Sprintf("1700000000.%06d", p.posts)}
+}
+
+func (p *fakePoster) Test(ctx context.Context) error {
+	return nil
+}
+
+func (p *fakePoster) PostMessage(ctx context.Context, channel, text string, asUser bool) (postedMessage, error) {
+	p.record(fmt.Sprintf("PostMessage %s as_user=%t", channel, asUser), text)
+	return p.posted(channel), nil
+}
+
+func (p *fakePoster) PostInThread(ctx context.Context, channel, text, threadTS string) (postedMessage, error) {
+	p.record(fmt.Sprintf("PostInThread %s thread_ts=%s", channel, threadTS), text)
+	return p.posted(channel), nil
+}
+
+func (p *fakePoster) PostBlocks(ctx context.Context, channel, text string, blocks []slackBlock) (postedMessage, error) {
+	p.record("PostBlocks "+channel, blocks)
+	return p.posted(channel), nil
+}
+
+func (p *fakePoster) PostAttachment(ctx context.Context, channel string, attachment slackAttachment) (postedMessage, error) {
+	p.record("PostAttachment "+channel, attachment)
+	return p.posted(channel), nil
+}
+
+func (p *fakePoster) ScheduleMessage(ctx context.Context, channel, text string, postAt time.Time) (string, error) {
+	p.record(fmt.Sprintf("ScheduleMessage %s post_at=%s", channel, postAt.Format(time.RFC3339)), text)
+	return "Q1", nil
+}
+
+func (p *fakePoster) DeleteMessage(ctx context.Context, pm postedMessage) error {
+	p.record("DeleteMessage "+pm.Channel+" "+pm.TS, "")
+	return nil
+}
+
+func (p *fakePoster) ListUsers(ctx context.Context) ([]slackMember, error) {
+	return p.users, nil
+}
+
+func (p *fakePoster) ListChannels(ctx context.Context, types string) ([]slackChannel, error) {
+	return p.channels, nil
+}
+
+func (p *fakePoster) JoinChannel(ctx context.Context, id string) error {
+	p.record("JoinChannel "+id, "")
+	return nil
+}
+
+func (p *fakePoster) PostWebhook(ctx context.Context, webhookURL string, payload interface{}) error {
+	p.record("PostWebhook "+webhookURL, payload)
+	return nil
+}
+
+func TestSlackPosterListUsers(t *testing.T) {
+	pages := map[string]string{
+		"":             `{"ok": true, "members": [{"id": "U001", "name": "alice", "profile": {"email": "alice@example.com", "display_name": "Alice"}}], "response_metadata": {"next_cursor": "dXNlcjpVMDAy"}}`,
+		"dXNlcjpVMDAy": `{"ok": true, "members": [{"id": "U002", "name": "bob", "profile": {"email": "bob@example.com"}}], "response_metadata": {"next_cursor": ""}}`,
+	}
+	var cursors []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/users.list" {
+			t.Errorf("unexpected method: %s", r.URL.Path)
+		}
+		if got := r.Header.Get("Authorization"); got != "Bearer xoxb-test" {
+			t.Errorf("Authorization = %q", got)
+		}
+		if got := r.FormValue("limit"); got != "200" {
+			t.Errorf("limit = %q", got)
+		}
+		cursor := r.FormValue("cursor")
+		cursors = append(cursors, cursor)
+		page, ok := pages[cursor]
+		if !ok {
+			t.Errorf("unexpected cursor: %q", cursor)
+			page = `{"ok": false, "error": "invalid_cursor"}`
+		}
+		fmt.Fprint(w, page)
+	}))
+	defer srv.Close()
+
+	p := newSlackPoster("xoxb-test")
+	p.endpoint = srv.URL + "/"
+	users, err := p.ListUsers(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cursors) != 2 || cursors[0] != "" || cursors[1] != "dXNlcjpVMDAy" {
+		t.Errorf("cursors = %q, want the first page and the next", cursors)
+	}
+	if len(users) != 2 {
+		t.Fatalf("%d users, want 2", len(users))
+	}
+	if users[0].ID != "U001" || users[0].DisplayName != "Alice" || users[0].Profile.Email != "alice@example.com" {
+		t.Errorf("users[0] = %+v", users[0])
+	}
+	if users[1].ID != "U002" || users[1].Name != "bob" {
+		t.Errorf("users[1] = %+v", users[1])
+	}
+}
+
+func TestSlackPosterListUsersError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"ok": false, "error": "missing_scope"}`)
+	}))
+	defer srv.Close()
+
+	p := newSlackPoster("xoxb-test")
+	p.endpoint = srv.URL + "/"
+	_, err := p.ListUsers(context.Background())
+	if e, ok := err.(*slackAPIError); !ok || e.Code != "missing_scope" {
+		t.Errorf("err = %v, want missing_scope", err)
+	}
+}
+
+// redmineIssuesJSON returns the response of /issues.json of the issues in the project.
+func redmineIssuesJSON(project int, ids ...int) string {
+	var ris []redmineIssue
+	for _, id := range ids {
+		ri := redmineIssue{}
+		ri.Id = id
+		ri.Subject = fmt.Sprintf("issue %d", id)
+		ri.Project = &redmine.IdName{Id: project}
+		ri.Status = &redmine.IdName{Id: 1, Name: "New"}
+		ris = append(ris, ri)
+	}
+	b, _ := json.Marshal(map[string]interface{}{"issues": ris})
+	return string(b)
+}
+
+func TestGetIssuesDuplicated(t *testing.T) {
+	defer func(p []redmine.Project, a int) { targetProjects, retryAttempts = p, a }(targetProjects, retryAttempts)
+	targetProjects = []redmine.Project{{Id: 1, Name: "Web"}, {Id: 2, Name: "App"}}
+	retryAttempts = 1
+
+	firstPage := make([]int, maxLimit)
+	for i := range firstPage {
+		firstPage[i] = i + 1
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		offset, _ := strconv.Atoi(r.FormValue("offset"))
+		switch key := r.FormValue("project_id") + "/" + strconv.Itoa(offset); key {
+		case "1/0":
+			fmt.Fprint(w, redmineIssuesJSON(1, firstPage...))
+		case "1/100":
+			// an issue updated while paging shifts to the next page
+			fmt.Fprint(w, redmineIssuesJSON(1, 100, 101))
+		case "2/0":
+			// the query of the parent project includes the issues of subprojects
+			fmt.Fprint(w, redmineIssuesJSON(2, 101, 201))
+		default:
+			t.Errorf("unexpected query: %s", key)
+			fmt.Fprint(w, `{"issues": []}`)
+		}
+	}))
+	defer srv.Close()
+
+	opts := redmineOptions{Endpoint: srv.URL, APIKey: "key"}
+	iss, err := getIssues(context.Background(), newRedmineFetcher(opts, false), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := map[int]int{}
+	for _, is := range iss {
+		seen[is.ID]++
+	}
+	for id, n := range seen {
+		if n > 1 {
+			t.Errorf("#%d is reported %d times", id, n)
+		}
+	}
+	if len(iss) != 102 {
+		t.Errorf("%d issues, want 102", len(iss))
+	}
+	for _, id := range []int{1, 100, 101, 201} {
+		if seen[id] != 1 {
+			t.Errorf("#%d is not reported", id)
+		}
+	}
+}
+
+func TestConvertIssuesDuplicated(t *testing.T) {
+	defer func(p []redmine.Project) { targetProjects = p }(targetProjects)
+	targetProjects = []redmine.Project{{Id: 1, Name: "Web"}}
+
+	var ris []redmineIssue
+	for _, id := range []int{1, 2, 1, 3, 2} {
+		ri := redmineIssue{}
+		ri.Id = id
+		ri.Project = &redmine.IdName{Id: 1}
+		ri.Status = &redmine.IdName{Id: 1}
+		ris = append(ris, ri)
+	}
+	var ids []int
+	for _, is := range convertIssues(ris, redmineOptions{}) {
+		ids = append(ids, is.ID)
+	}
+	if fmt.Sprint(ids) != "[1 2 3]" {
+		t.Errorf("ids = %v, want [1 2 3] in the order of first appearance", ids)
+	}
+}
+
+func TestConvertIssuesExcludeStatus(t *testing.T) {
+	defer func(p []redmine.Project) { targetProjects = p }(targetProjects)
+	targetProjects = []redmine.Project{{Id: 1, Name: "Web"}}
+
+	var ris []redmineIssue
+	for id, status := range []redmine.IdName{{Id: 1, Name: "New"}, {Id: 2, Name: "In Progress"}, {Id: 4, Name: "Feedback"}} {
+		ri := redmineIssue{}
+		ri.Id = id + 1
+		ri.Project = &redmine.IdName{Id: 1}
+		ri.Status = &redmine.IdName{Id: status.Id, Name: status.Name}
+		ris = append(ris, ri)
+	}
+	tests := []struct {
+		name    string
+		exclude []string
+		want    string
+	}{
+		{"none", nil, "[1 2 3]"},
+		{"repeated", []string{"New", "4"}, "[2]"},
+		{"comma separated", []string{"New,Feedback"}, "[2]"},
+		{"comma separated with spaces", []string{"1, In Progress"}, "[3]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var ids []int
+			for _, is := range convertIssues(ris, redmineOptions{ExcludeStatus: tt.exclude}) {
+				ids = append(ids, is.ID)
+			}
+			if got := fmt.Sprint(ids); got != tt.want {
+				t.Errorf("ids = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetRedmineAPIKey(t *testing.T) {
+	defer func(a int) { retryAttempts = a }(retryAttempts)
+	retryAttempts = 1
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("X-Redmine-API-Key"); got != "SECRETKEY" {
+			t.Errorf("X-Redmine-API-Key = %q, want SECRETKEY", got)
+		}
+		if strings.Contains(r.URL.RawQuery, "SECRETKEY") {
+			t.Errorf("the API key is in the query: %s", r.URL.RawQuery)
+		}
+		fmt.Fprint(w, `{"issue_statuses": []}`)
+	}))
+	defer srv.Close()
+
+	opts := redmineOptions{Endpoint: srv.URL, APIKey: "SECRETKEY"}
+	f := newRedmineFetcher(opts, false)
+	if _, err := f.FetchPriorities(context.Background(), opts); err != nil {
+		t.Error(err)
+	}
+	// go-redmine builds the URL by itself
+	if _, err := f.FetchStatuses(context.Background(), opts); err != nil {
+		t.Error(err)
+	}
+
+	// nothing listens on the port, so the request fails with its URL
+	opts.Endpoint = "http://127.0.0.1:1"
+	_, err := newRedmineFetcher(opts, false).FetchPriorities(context.Background(), opts)
+	if err == nil {
+		t.Fatal("no error from unreachable Redmine")
+	}
+	if strings.Contains(err.Error(), "SECRETKEY") {
+		t.Errorf("the error has the API key: %s", err)
+	}
+}
+
+func TestGetIssuesPartialFailure(t *testing.T) {
+	defer func(p redmine.Project, ps []redmine.Project, f []string, a int) {
+		targetProject, targetProjects, targetFailures, retryAttempts = p, ps, f, a
+	}(targetProject, targetProjects, targetFailures, retryAttempts)
+	targetProjects = []redmine.Project{{Id: 1, Name: "Web"}, {Id: 2, Name: "App"}}
+	targetFailures = nil
+	retryAttempts = 1
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("project_id") == "2" {
+			http.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprint(w, redmineIssuesJSON(1, 1))
+	}))
+	defer srv.Close()
+
+	opts := redmineOptions{Endpoint: srv.URL, APIKey: "SECRETKEY", PartialFailureOK: true}
+	iss, err := getIssues(context.Background(), newRedmineFetcher(opts, false), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(iss) != 1 {
+		t.Errorf("%d issues, want 1", len(iss))
+	}
+	want := []string{fmt.Sprintf(messages.FetchFailed, "App", "redmine: 500 Internal Server Error")}
+	if !reflect.DeepEqual(targetFailures, want) {
+		t.Errorf("targetFailures = %q, want %q", targetFailures, want)
+	}
+}
+
+func TestGetIssuesFallback(t *testing.T) {
+	defer func(p []redmine.Project, a int) { targetProjects, retryAttempts = p, a }(targetProjects, retryAttempts)
+	targetProjects = []redmine.Project{{Id: 1, Name: "Web"}}
+	retryAttempts = 1
+
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	tests := []struct {
+		name     string
+		status   int
+		endpoint string
+		ctx      context.Context
+		fallback bool
+	}{
+		{name: "bad request", status: http.StatusBadRequest, fallback: true},
+		{name: "unprocessable entity", status: http.StatusUnprocessableEntity, fallback: true},
+		{name: "internal server error", status: http.StatusInternalServerError, fallback: true},
+		{name: "unauthorized", status: http.StatusUnauthorized},
+		{name: "forbidden", status: http.StatusForbidden},
+		{name: "too many requests", status: http.StatusTooManyRequests},
+		{name: "unreachable", endpoint: "http://redmine.invalid"},
+		{name: "canceled", ctx: canceled},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var all int
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.FormValue("project_id") != "" {
+					http.Error(w, "", tt.status)
+					return
+				}
+				all++
+				fmt.Fprint(w, redmineIssuesJSON(1, 1))
+			}))
+			defer srv.Close()
+
+			opts := redmineOptions{Endpoint: srv.URL, APIKey: "key"}
+			if tt.endpoint != "" {
+				opts.Endpoint = tt.endpoint
+			}
+			ctx := context.Background()
+			if tt.ctx != nil {
+				ctx = tt.ctx
+			}
+			iss, err := getIssues(ctx, newRedmineFetcher(opts, false), opts)
+			if !tt.fallback {
+				if err == nil {
+					t.Errorf("no error, %d issues by fetching all issues", len(iss))
+				}
+				if all > 0 {
+					t.Errorf("all issues are fetched %d times", all)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if all != 1 || len(iss) != 1 {
+				t.Errorf("all issues are fetched %d times and %d issues, want once and 1", all, len(iss))
+			}
+		})
+	}
+}
+
+func TestFetchIssuesClosedSince(t *testing.T) {
+	defer func(p []redmine.Project, c string, a int) { targetProjects, closedSince, retryAttempts = p, c, a }(targetProjects, closedSince, retryAttempts)
+	targetProjects = []redmine.Project{{Id: 1, Name: "Web"}}
+	closedSince = "2020-04-01"
+	retryAttempts = 1
+
+	var queries []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queries = append(queries, r.FormValue("status_id")+" "+r.FormValue("updated_on"))
+		if r.FormValue("status_id") == "c" {
+			fmt.Fprint(w, redmineIssuesJSON(1, 2))
+			return
+		}
+		fmt.Fprint(w, redmineIssuesJSON(1, 1))
+	}))
+	defer srv.Close()
+
+	opts := redmineOptions{Endpoint: srv.URL, APIKey: "key"}
+	ris, err := newRedmineFetcher(opts, false).FetchIssues(context.Background(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ris) != 2 {
+		t.Errorf("%d issues, want 2", len(ris))
+	}
+	want := []string{" ", "c >=2020-04-01"}
+	if !reflect.DeepEqual(queries, want) {
+		t.Errorf("queries = %q, want %q", queries, want)
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	flags "github.com/jessevdk/go-flags"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// loadConfig returns the options in the config file given by --config as flags,
+// to be parsed before the command line arguments.
+// The options given by flags or environment variables are skipped,
+// so that they take precedence over the config file.
+func loadConfig(args []string) ([]string, error) {
+	var opts options
+	parser := flags.NewParser(&opts, flags.IgnoreUnknown)
+	if _, err := parser.ParseArgs(args); err != nil {
+		// required options may be given by the config file,
+		// and other errors are reported when the arguments are parsed again
+		if fe, ok := err.(*flags.Error); !ok || fe.Type != flags.ErrRequired {
+			return nil, nil
+		}
+	}
+	if opts.Config == "" {
+		return nil, nil
+	}
+
+	b, err := ioutil.ReadFile(opts.Config)
+	if err != nil {
+		return nil, err
+	}
+	config := map[string]interface{}{}
+	switch filepath.Ext(opts.Config) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(b, &config)
+	default:
+		err = json.Unmarshal(b, &config)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid config file: %s", err)
+	}
+
+	var names []string
+	for name := range config {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var configArgs []string
+	for _, name := range names {
+		option := parser.FindOptionByLongName(name)
+		if option == nil {
+			return nil, fmt.Errorf("unknown option in config file: %s", name)
+		}
+		if option.IsSet() && !option.IsSetDefault() {
+			continue
+		}
+		// the env tag, as no group of the options has env namespace
+		if env := option.EnvDefaultKey; env != "" {
+			if _, ok := os.LookupEnv(env); ok {
+				continue
+			}
+		}
+		configArgs = append(configArgs, configFlags(name, config[name])...)
+	}
+	return configArgs, nil
+}
+
+// configFlags converts the value in the config file to flags.
+// Lists are given as repeated flags and maps as repeated key:value flags.
+func configFlags(name string, v interface{}) []string {
+	switch v := v.(type) {
+	case bool:
+		if v {
+			return []string{"--" + name}
+		}
+		return nil
+	case []interface{}:
+		var fs []string
+		for _, e := range v {
+			fs = append(fs, configFlags(name, e)...)
+		}
+		return fs
+	case map[string]interface{}:
+		var fs []string
+		for k, e := range v {
+			fs = append(fs, fmt.Sprintf("--%s=%s:%s", name, k, configValue(e)))
+		}
+		return fs
+	case map[interface{}]interface{}:
+		var fs []string
+		for k, e := range v {
+			fs = append(fs, fmt.Sprintf("--%s=%v:%s", name, k, configValue(e)))
+		}
+		return fs
+	}
+	return []string{fmt.Sprintf("--%s=%s", name, configValue(v))}
+}
+
+func configValue(v interface{}) string {
+	if f, ok := v.(float64); ok {
+		// numbers in JSON are decoded as float64
+		return strconv.FormatFloat(f, 'f', -1, 64)
+	}
+	return fmt.Sprint(v)
+}
+
+// readSecretFile reads the secret from the file given by the environment variable,
+// such as Docker secrets, overriding the value given by the flag or the variable without _FILE.
+// Trailing newlines of the file are trimmed.
+func readSecretFile(env string, value *string) error {
+	path := os.Getenv(env)
+	if path == "" {
+		return nil
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %s", env, err)
+	}
+	*value = strings.TrimRight(string(b), "\r\n")
+	return nil
+}
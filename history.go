@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+)
+
+// historyEntry is the counts of expired and near issues of a project at a run,
+// used to tell the trend week over week.
+type historyEntry struct {
+	Project string `json:"project"`
+	Date    string `json:"date"`
+	Expired int    `json:"expired"`
+	Near    int    `json:"near"`
+}
+
+// lastWeek is the counts of the last week to be compared with, nil when unknown.
+var lastWeek *historyEntry
+
+// loadHistory loads the history from the file.
+// A missing file is considered as no history.
+func loadHistory(path string) ([]historyEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+	var entries []historyEntry
+	if err := json.NewDecoder(f).Decode(&entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+func saveHistory(path string, entries []historyEntry) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return json.NewEncoder(f).Encode(entries)
+}
+
+// findLastWeek returns the latest entry of the project recorded a week or more ago.
+func findLastWeek(entries []historyEntry, project string) *historyEntry {
+	weekAgo := today.AddDate(0, 0, -7).Format("2006-01-02")
+	var found *historyEntry
+	for i, e := range entries {
+		if e.Project != project || e.Date > weekAgo {
+			continue
+		}
+		if found == nil || e.Date > found.Date {
+			found = &entries[i]
+		}
+	}
+	return found
+}
+
+// recordHistory adds the counts of the project to the file,
+// replacing the entry recorded on the same day.
+func recordHistory(path, project string, expired, near int) error {
+	infof("record history")
+	entries, err := loadHistory(path)
+	if err != nil {
+		return err
+	}
+	entry := historyEntry{Project: project, Date: today.Format("2006-01-02"), Expired: expired, Near: near}
+	var kept []historyEntry
+	for _, e := range entries {
+		if e.Project != entry.Project || e.Date != entry.Date {
+			kept = append(kept, e)
+		}
+	}
+	return saveHistory(path, append(kept, entry))
+}
@@ -0,0 +1,44 @@
+package main
+
+import "log"
+
+// levels of logging
+const (
+	levelQuiet = iota // errors and warnings only
+	levelInfo         // progress of the run, the default
+	levelDebug        // decisions on each issue as well
+)
+
+// logLevel is the level of logging, set from the options.
+var logLevel = levelInfo
+
+// setLogLevel sets the level of logging by the flags, quiet taking precedence.
+func setLogLevel(verbose, quiet bool) {
+	switch {
+	case quiet:
+		logLevel = levelQuiet
+	case verbose:
+		logLevel = levelDebug
+	default:
+		logLevel = levelInfo
+	}
+}
+
+// warnf logs errors and warnings, which are logged regardless of the level.
+func warnf(format string, v ...interface{}) {
+	log.Printf(format, v...)
+}
+
+// infof logs the progress of the run, suppressed by --quiet.
+func infof(format string, v ...interface{}) {
+	if logLevel >= levelInfo {
+		log.Printf(format, v...)
+	}
+}
+
+// debugf logs the details of the run, shown by --verbose.
+func debugf(format string, v ...interface{}) {
+	if logLevel >= levelDebug {
+		log.Printf(format, v...)
+	}
+}
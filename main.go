@@ -3,6 +3,7 @@ Redmine-issue-summary is a weekly report slack bot which summarize redmine ticke
 There's a workaround:
 1. issue filtering by project id
   * mattn/go-redmine 's Client.IssuesOf() causes exception on redmine_issues_tree plugin
+  - issues are filtered by project_id query instead, and by project id after fetching all issues if it is rejected
 */
 package main
 
@@ -12,47 +13,196 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/url"
 	"os"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	flags "github.com/jessevdk/go-flags"
-	"github.com/lestrrat-go/slack"
 	"github.com/lestrrat-go/slack/objects"
 	redmine "github.com/mattn/go-redmine"
+	"golang.org/x/text/unicode/norm"
 )
 
 type options struct {
-	Redmine redmineOptions
-	Slack   slackOptions
+	Redmine          redmineOptions
+	Slack            slackOptions
+	TestMatch        string        `long:"test-match" description:"Print whether given \"redmine-login slack-user\" pair is matched and exit, the slack user is given by name, real name, display name or email"`
+	MaxRuntime       time.Duration `long:"max-runtime" default:"5m" description:"Abort the run when it takes longer than this duration, 0 for no limit"`
+	BucketAssignment string        `long:"bucket-assignment" choice:"all" choice:"first-match" default:"all" description:"Put an issue in all matching sections or only the first one"`
+	SnapshotFile     string        `long:"snapshot-file" description:"Path to the file to record snapshots of open issues"`
+	Digest           string        `long:"digest" choice:"weekly" description:"Post the digest of snapshots instead of the report"`
+	Validate         bool          `long:"validate" description:"Check connectivity to Redmine and Slack without posting and exit"`
+	DryRun           bool          `long:"dry-run" description:"Print the report to stdout instead of posting it to Slack"`
+	Config           string        `long:"config" description:"Path to YAML or JSON file of options keyed by long flag names, overridden by flags and environment variables"`
+	RetryAttempts    int           `long:"retry-attempts" default:"3" description:"Number of attempts of each call to Redmine and Slack"`
+	RetryBackoff     time.Duration `long:"retry-backoff" default:"1s" description:"Delay before the first retry, doubled for each retry"`
+	FailOnExpired    bool          `long:"fail-on-expired" description:"Exit with code 2 when there are expired issues, after posting the report"`
+	Timezone         string        `long:"timezone" description:"Timezone to tell the dates of issues, e.g. Asia/Tokyo (default: local timezone)"`
+	OutputFile       string        `long:"output-file" description:"Path to write the report as Markdown, besides posting to Slack"`
+	Verbose          bool          `short:"v" long:"verbose" description:"Log decisions on each issue as well"`
+	Quiet            bool          `short:"q" long:"quiet" description:"Log errors and warnings only"`
+	HistoryFile      string        `long:"history-file" description:"Path to the file to record the counts of issues per run, to show the change since last week"`
 }
 
 type redmineOptions struct {
-	APIKey         string `short:"k" long:"redmine-apikey" env:"REDMINE_APIKEY" required:"true" description:"APIKey for your Redmine"`
-	Endpoint       string `short:"r" long:"redmine-endpoint" env:"REDMINE_ENDPOINT" requireid:"true" description:"Endpoint URL of your Redmine"`
-	Project        string `short:"p" long:"redmine-project" env:"REDMINE_PROJECT" required:"true" description:"Target project of Redmine"`
-	FinishedStatus []int  `short:"f" long:"redmine-finished-status" description:"IDs of status considered as finished"`
+	APIKey               string        `short:"k" long:"redmine-apikey" env:"REDMINE_APIKEY" description:"APIKey for your Redmine, required unless REDMINE_APIKEY_FILE or issues-file is given"`
+	Endpoint             string        `short:"r" long:"redmine-endpoint" env:"REDMINE_ENDPOINT" required:"true" description:"Endpoint URL of your Redmine"`
+	Project              string        `short:"p" long:"redmine-project" env:"REDMINE_PROJECT" description:"Comma separated IDs or names of target projects of Redmine, required unless the scope is mine"`
+	FinishedStatus       []string      `short:"f" long:"redmine-finished-status" description:"Comma separated IDs or names of status considered as finished, names are not supported with issues-file"`
+	SLA                  string        `long:"sla" description:"Acceptable days since creation per priority, e.g. High=1,Normal=3"`
+	ExcludeStatus        []string      `long:"exclude-status" description:"Comma separated IDs or names of status to be excluded from the report"`
+	Scope                string        `long:"scope" choice:"all" choice:"watched" choice:"mine" default:"all" description:"Which issues are reported"`
+	Watcher              string        `long:"watcher" default:"me" description:"ID or login of the watcher for watched scope"`
+	DumpIssues           string        `long:"dump-issues" description:"Path to write raw issues fetched from Redmine as JSON"`
+	ShowStatusBreakdown  bool          `long:"show-status-breakdown" description:"Show the number of open issues per status"`
+	IncludeGroupAssigned bool          `long:"include-group-assigned" description:"Detect issues assigned to groups and render them as groups"`
+	IgnoreNotStarted     bool          `long:"ignore-not-started" description:"Do not report issues whose start date is in the future as expired or near"`
+	TagsField            string        `long:"tags-field" default:"tags" description:"ID or name of the custom field holding tags of redmine_tags plugin"`
+	IncludeFinished      bool          `long:"include-finished" description:"Report issues in finished status too, to verify finished statuses"`
+	EmailDomainMap       []string      `long:"email-domain-map" description:"Rewrite the domain of Redmine users' email before matching, e.g. corp.local=corp.com"`
+	IssuesFile           string        `long:"issues-file" description:"Path to JSON file of issues exported from Redmine, used instead of Redmine API"`
+	UsersFile            string        `long:"users-file" description:"Path to JSON file of users exported from Redmine, used with issues-file to match users by login and email"`
+	RequireDueDate       bool          `long:"require-duedate" description:"List the issues without due date to request setting it"`
+	MaxUndated           int           `long:"max-undated" default:"-1" description:"Fail when the issues without due date are more than this number, with --require-duedate"`
+	Author               []string      `long:"author" description:"IDs, logins or names of the authors of issues to be reported"`
+	ClientSideFilter     bool          `long:"client-side-project-filter" description:"Fetch all issues and filter them by project locally, for Redmine rejecting project_id filter"`
+	NearDays             int           `long:"near-days" description:"Days from today to consider issues as near deadline (default: until this Friday)"`
+	NoCache              bool          `long:"no-cache" description:"Do not use the cache of Redmine users and projects"`
+	CacheTTL             time.Duration `long:"cache-ttl" default:"1h" description:"Duration to reuse the cache of Redmine users and projects"`
+	CacheDir             string        `long:"cache-dir" description:"Directory of the cache of Redmine users and projects (default: user cache directory)"`
+	Tracker              []string      `long:"tracker" description:"IDs or names of the trackers of issues to be reported"`
+	MinPriority          string        `long:"min-priority" description:"ID or name of the lowest priority of issues to be reported"`
+	Since                int           `long:"since" description:"Report only issues updated within this number of days"`
+	DateLayout           string        `long:"date-layout" default:"2006-01-02" description:"Layout of dates of issues in Go's time format"`
+	ExcludeAssignee      []string      `long:"exclude-assignee" description:"IDs, logins or names of users or groups whose issues are excluded, even if matched by --author"`
+	CustomField          string        `long:"custom-field" description:"ID or name of the custom field shown in parentheses of each issue, e.g. sprint"`
+	BasicAuthUser        string        `long:"redmine-basic-auth-user" env:"REDMINE_BASIC_AUTH_USER" description:"User of HTTP basic auth of the proxy in front of Redmine"`
+	BasicAuthPassword    string        `long:"redmine-basic-auth-password" env:"REDMINE_BASIC_AUTH_PASSWORD" description:"Password of HTTP basic auth of the proxy in front of Redmine"`
+	Header               string        `long:"redmine-header" env:"REDMINE_HEADER" description:"Header sent to Redmine in \"Name: value\" format, e.g. for the proxy in front of Redmine"`
+	ExpandGroup          bool          `long:"expand-group-assigned" description:"Mention the members of groups assigned to issues, with --include-group-assigned"`
+	QueryID              int           `long:"redmine-query-id" description:"ID of the saved query of Redmine to filter issues on Redmine, combined with the other filters"`
+	PartialFailureOK     bool          `long:"partial-failure-ok" description:"Report the projects fetched successfully even if the others fail, noting the failures, and exit with code 5"`
+	StrictFinishedStatus bool          `long:"strict-finished-status" description:"Fail when a finished status does not exist on Redmine, instead of warning"`
 }
 
 type slackOptions struct {
-	Token   string `short:"t" long:"slack-token" env:"SLACK_TOKEN" required:"true" description:"Slack API Token"`
-	Channel string `short:"c" long:"slack-channel" env:"SLACK_CHANNEL" default:"#general" description:"Slack channel you want to post"`
+	Token               string            `short:"t" long:"slack-token" env:"SLACK_TOKEN" description:"Slack API Token, required unless --slack-webhook-url is given"`
+	Channel             string            `short:"c" long:"slack-channel" env:"SLACK_CHANNEL" default:"#general" description:"Comma separated Slack channels you want to post"`
+	PostAt              string            `long:"post-at" description:"Schedule the post at given time (RFC3339) instead of posting now"`
+	AssigneeLink        bool              `long:"assignee-link" description:"Add a link to all open issues of the assignee"`
+	PlainIssueIDs       bool              `long:"plain-issue-ids" description:"Render issue IDs as plain text followed by the URL instead of links"`
+	OpsChannel          string            `long:"ops-channel" description:"Slack channel to post the run summary for operators"`
+	NameRules           string            `long:"name-rules" description:"Path to JSON file of regexp rules to normalize names before matching users"`
+	ThreadByAssignee    bool              `long:"thread-by-assignee" description:"Post only the counts, then reply expired issues in the thread per assignee"`
+	GroupMapping        map[string]string `long:"slack-group-mapping" description:"Slack usergroup ID to mention for Redmine group, e.g. Dev:S0123ABCD"`
+	LineFields          string            `long:"line-fields" default:"duedate,id,subject,assignee,status" description:"Comma separated fields of each line in order, from id, subject, duedate, assignee, priority, status and tracker"`
+	PostAsUser          bool              `long:"post-as-user" description:"Post as the owner of the user token (xoxp-) instead of the bot"`
+	ShowTags            bool              `long:"show-tags" description:"Show tags of each issue"`
+	ShowOverdueRate     bool              `long:"show-overdue-rate" description:"Show the rate of expired issues in open issues"`
+	MaxMessageAge       time.Duration     `long:"max-message-age" description:"Delete the reports posted before than this duration when a new one is posted"`
+	MessageLog          string            `long:"message-log" default:"./posted_messages.json" description:"Path to the file to track posted reports for --max-message-age"`
+	Footer              string            `long:"footer" description:"Message appended after all sections, {date} is replaced with today"`
+	FooterFile          string            `long:"footer-file" description:"Path to the file of the footer message, used when --footer is not given"`
+	ShowAssigneeChanges bool              `long:"show-assignee-changes" description:"Show the change of assignee since the last snapshot, with --snapshot-file"`
+	RollupByAssignee    bool              `long:"rollup-by-assignee" description:"Show a line of counts per assignee instead of each issue"`
+	ErrorChannel        string            `long:"error-channel" description:"Slack channel to notify when the run fails"`
+	SuggestUserMap      string            `long:"suggest-usermap" description:"Path to JSON file to append usermapping suggestions for unmatched users"`
+	ShowAverageOverdue  bool              `long:"show-average-overdue" description:"Show the average days overdue of expired issues"`
+	WorkflowWebhook     string            `long:"workflow-webhook" description:"Post the report as JSON payload to this Workflow Builder webhook URL instead of a message"`
+	NearSplit           string            `long:"near-split" description:"Split near issues by days remaining into labeled sections, e.g. 今日=0,明日=1,今週=7"`
+	ShowReopened        bool              `long:"show-reopened" description:"Show the issues reopened since the last snapshot, with --snapshot-file"`
+	Sample              int               `long:"sample" description:"Show only this number of expired issues, chosen by the date of the run"`
+	TrackerEmoji        []string          `long:"tracker-emoji" description:"Emoji for the tracker field of each line, e.g. Bug=:bug:"`
+	GroupByAssignee     bool              `long:"group-by-assignee" description:"Group expired and near issues by assignee"`
+	BlockKit            bool              `long:"block-kit" description:"Post the report as Block Kit sections divided per category, same as --slack-format=blocks"`
+	Lang                string            `long:"lang" default:"ja" description:"Language of the report, ja or en (unknown languages fall back to en)"`
+	ThreadNear          bool              `long:"thread-near" description:"Post the expired issues, then reply the near issues and the rest in its thread"`
+	HideStatus          bool              `long:"hide-status" description:"Do not show the status of each line"`
+	WebhookURL          string            `long:"slack-webhook-url" env:"SLACK_WEBHOOK_URL" description:"Incoming webhook URL to post the report instead of the token, @mentions are not resolved"`
+	ShowTotals          bool              `long:"show-totals" description:"Show the total of open issues with the counts of expired and near ones"`
+	Limit               int               `long:"limit" description:"Show at most this number of issues in each of the expired and near sections, unlimited by default"`
+	SkipEmpty           bool              `long:"skip-empty" description:"Skip posting when there are no expired or near issues"`
+	AllClearMessage     string            `long:"all-clear-message" description:"Message posted instead of the report when there are no expired or near issues"`
+	ExpiredEmoji        string            `long:"expired-emoji" description:"Emoji put at the head of each expired issue, e.g. :red_circle:"`
+	NearEmoji           string            `long:"near-emoji" description:"Emoji put at the head of each near issue, e.g. :large_yellow_circle:"`
+	MentionExpiredOnly  bool              `long:"mention-expired-only" description:"Show names instead of mentions in the near section, to mention assignees only of expired issues"`
+	UserMap             string            `long:"usermap" default:"./usermapping.json" description:"Path to JSON file mapping real names of Slack users to names of Redmine users"`
+	ProjectUserMap      string            `long:"project-usermap" description:"Path to JSON file of usermapping for the project, whose entries take precedence over --usermap"`
+	Format              string            `long:"slack-format" choice:"text" choice:"blocks" choice:"attachment" default:"text" description:"Format of the message, attachment is colored by the severity"`
+	SortWithinGroup     string            `long:"sort-within-group" choice:"duedate" choice:"priority" choice:"id" default:"duedate" description:"Order of issues in each group of assignees: by due date, by priority descending or by ID"`
+	RichPerIssue        bool              `long:"rich-per-issue" description:"Post each expired and near issue as its own section colored by days overdue, with --block-kit"`
+	RichOverdueDays     string            `long:"rich-overdue-days" default:"3,7" description:"Days overdue from which issues are colored orange and red, with --rich-per-issue"`
+	GroupSeparator      string            `long:"group-separator" description:"Separator between groups of grouped output: blank, divider or any string"`
+	HideGroupCounts     bool              `long:"hide-group-counts" description:"Do not show the count of issues of each group in grouped output"`
+	SortGroups          string            `long:"sort-groups" choice:"name" choice:"count" description:"Order groups of assignees by name or by count descending (default: by name, by count for --rollup-by-assignee, by due date for --thread-by-assignee)"`
+	UnresolvedSuffix    string            `long:"unresolved-assignee-suffix" description:"Suffix of the names of assignees not found on Redmine such as deleted users, defaults by --lang"`
+}
+
+// redmineIssue is an issue of Redmine API.
+// go-redmine does not decode some fields of issues, so they are decoded here.
+type redmineIssue struct {
+	redmine.Issue
+	StartDate string `json:"start_date"`
 }
 
 type issue struct {
 	ID         int
 	Subject    string
 	DueDate    time.Time
+	StartDate  time.Time
+	CreatedOn  time.Time
+	Priority   string
+	PriorityID int
+	Status     string
 	AssignedTo *redmine.IdName
+	Tags       []string
+	Author     *redmine.IdName
+	StatusID   int
+	Tracker    string
+	ProjectID  int
+	// CustomField is the value of the custom field given by --custom-field
+	CustomField string
 }
 
 type redmineUserMap struct {
 	m sync.Map
 }
 
+// nameRule is a regexp-based rule to normalize names before matching users.
+type nameRule struct {
+	Pattern     string `json:"pattern"`
+	Replacement string `json:"replacement"`
+
+	re *regexp.Regexp
+}
+
+// warningList accumulates non-fatal warnings during the run.
+type warningList struct {
+	mu sync.Mutex
+	ws []string
+}
+
+func (wl *warningList) Add(w string) {
+	wl.mu.Lock()
+	defer wl.mu.Unlock()
+	wl.ws = append(wl.ws, w)
+}
+
+func (wl *warningList) List() []string {
+	wl.mu.Lock()
+	defer wl.mu.Unlock()
+	return append([]string{}, wl.ws...)
+}
+
 func (rum *redmineUserMap) Set(id int, user redmine.User) {
 	rum.m.Store(id, user)
 }
@@ -69,278 +219,2743 @@ func (rum *redmineUserMap) Get(id int) (redmine.User, error) {
 	return u, nil
 }
 
+func (rum *redmineUserMap) List() []redmine.User {
+	var users []redmine.User
+	rum.m.Range(func(_, ui interface{}) bool {
+		if u, ok := ui.(redmine.User); ok {
+			users = append(users, u)
+		}
+		return true
+	})
+	return users
+}
+
+func (rum *redmineUserMap) GetByLogin(login string) (redmine.User, error) {
+	var user redmine.User
+	var found bool
+	rum.m.Range(func(_, ui interface{}) bool {
+		u, ok := ui.(redmine.User)
+		if ok && u.Login == login {
+			user = u
+			found = true
+			return false
+		}
+		return true
+	})
+	if !found {
+		return redmine.User{}, errors.New("the user is not found")
+	}
+	return user, nil
+}
+
+// exit codes
+const (
+	exitOK      = 0
+	exitError   = 1
+	exitExpired = 2
+	exitTimeout = 3
+	exitUndated = 4
+	exitPartial = 5
+)
+
+var (
+	errMaxRuntimeExceeded = errors.New("max runtime exceeded, Redmine or Slack may not be responding")
+	errTooManyUndated     = errors.New("too many issues without due date")
+	errExpiredIssues      = errors.New("there are expired issues")
+	errPartialFailure     = errors.New("failed to fetch some of the projects")
+)
+
+// bucket assignment policies
+const (
+	bucketAssignmentAll        = "all"
+	bucketAssignmentFirstMatch = "first-match"
+)
+
+// formats of the message
+const (
+	formatText       = "text"
+	formatBlocks     = "blocks"
+	formatAttachment = "attachment"
+)
+
+// orders of groups
+const (
+	sortGroupsByName  = "name"
+	sortGroupsByCount = "count"
+)
+
+// separators between groups, other values are put as is
+const (
+	groupSeparatorBlank   = "blank"
+	groupSeparatorDivider = "divider"
+)
+
+// digest modes
+const (
+	digestWeekly = "weekly"
+)
+
+// scopes of issues to be reported
+const (
+	scopeAll     = "all"
+	scopeWatched = "watched"
+	scopeMine    = "mine"
+)
+
 const (
 	// maxLimit is maximum Limit for Redmine's issue API.
 	maxLimit = 100
+	// slackUsersPageSize is the number of users listed at once, as recommended by Slack.
+	slackUsersPageSize = 200
+	// slackAPIEndpoint is base URL of Slack Web API,
+	// used for methods lestrrat-go/slack does not support.
+	slackAPIEndpoint = "https://slack.com/api/"
 )
 
+// clock returns the current time, which the reference time of the run is set from.
+var clock = time.Now
+
+// reference time of the run which issues are evaluated at, set by initialize
 var (
-	now     = time.Now()
-	today   = time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local)
-	weekend = today.Add(time.Duration(5-today.Weekday()) * time.Hour * 24)
+	now      time.Time
+	today    time.Time
+	weekend  time.Time // the deadline for near issues
+	location = time.Local
 )
 
 var (
-	userMap       = loadUserMap()
-	slackClient   *slack.Client
-	slackUsers    objects.UserList
-	redmineClient *redmine.Client
-	redmineUsers  redmineUserMap
-	targetProject redmine.Project // workaround(1)
+	userMap          map[string]string
+	slackUsers       []slackMember
+	redmineUsers     redmineUserMap
+	unmatchedUsers   redmineUserMap
+	redmineGroups    map[int]string
+	allUsersLoaded   bool                     // all users and groups of Redmine are loaded, so the other assignees are unresolved
+	groupMembers     map[int][]redmine.IdName // members of groups by group ID, with --expand-group-assigned
+	targetProject    redmine.Project          // workaround(1), combined one of targetProjects for headers
+	targetProjects   []redmine.Project
+	targetFailures   []string // projects failed to be fetched, with --partial-failure-ok
+	slaWindows       map[string]int
+	warnings         warningList
+	nameRules        []nameRule
+	finishedStatuses []int // IDs of finished statuses resolved from the options
+	lineFields       []string
+	ignoreNotStarted bool
+	requireDueDate   bool
+	assigneeChanges  map[int]*redmine.IdName
+	nearSplits       []nearSplit
+	trackerEmojis    map[string]string
+	richOverdueDays  [2]int // days overdue from which issues are colored orange and red
+	dateLayout       string
+	priorityRanks    map[int]int // rank of priorities by ID, nil unless loaded for the threshold or sorting
+	minPriorityRank  int
+	finishedIssues   []issue
+	reopenedIssues   []issue
+	closedSince      string // closed issues updated since the date are fetched too, with --show-reopened
 )
 
 func main() { os.Exit(_main()) }
 
 func _main() int {
 	if err := exec(); err != nil {
-		log.Print(err)
-		return 1
+		warnf("%s", err)
+		switch err {
+		case errMaxRuntimeExceeded:
+			return exitTimeout
+		case errTooManyUndated:
+			return exitUndated
+		case errExpiredIssues:
+			return exitExpired
+		case errPartialFailure:
+			return exitPartial
+		}
+		return exitError
 	}
-	return 0
+	return exitOK
 }
 
 func exec() error {
-	log.Print("parse flags")
+	args := os.Args[1:]
+	configArgs, err := loadConfig(args)
+	if err != nil {
+		return err
+	}
 	var opts options
-	if _, err := flags.Parse(&opts); err != nil {
+	if _, err := flags.ParseArgs(&opts, append(configArgs, args...)); err != nil {
 		if fe, ok := err.(*flags.Error); ok && fe.Type == flags.ErrHelp {
 			return nil
 		}
 		return err
 	}
-	if err := initialize(opts); err != nil {
+	setLogLevel(opts.Verbose, opts.Quiet)
+	infof("parse flags")
+	if err := readSecretFile("REDMINE_APIKEY_FILE", &opts.Redmine.APIKey); err != nil {
+		return err
+	}
+	if err := readSecretFile("SLACK_TOKEN_FILE", &opts.Slack.Token); err != nil {
 		return err
 	}
-	iss, err := getIssues(opts.Redmine)
+	// Redmine is not called in offline mode
+	if opts.Redmine.APIKey == "" && opts.Redmine.IssuesFile == "" {
+		return errors.New("redmine-apikey is required")
+	}
+	userMap = loadUserMap(opts.Slack.UserMap, opts.Slack.ProjectUserMap)
+	retryAttempts, retryBackoff = opts.RetryAttempts, opts.RetryBackoff
+	endpoint, err := normalizeEndpoint(opts.Redmine.Endpoint)
 	if err != nil {
 		return err
 	}
-	out := fanout(iss, isExpired, isNear)
+	opts.Redmine.Endpoint = endpoint
+	if _, _, err := parseHeader(opts.Redmine.Header); err != nil {
+		return err
+	}
+	switch {
+	case opts.Slack.Token != "" && opts.Slack.WebhookURL != "":
+		return errors.New("slack-token and slack-webhook-url cannot be given together")
+	case opts.Slack.Token == "" && opts.Slack.WebhookURL == "":
+		return errors.New("slack-token or slack-webhook-url is required")
+	}
+	ctx := context.Background()
+	if opts.MaxRuntime > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.MaxRuntime)
+		defer cancel()
+	}
+	fetcher := newRedmineFetcher(opts.Redmine, opts.Redmine.IncludeFinished)
+	poster := newSlackPoster(opts.Slack.Token)
+	if opts.Validate {
+		return validate(ctx, opts, fetcher, poster)
+	}
+	if opts.TestMatch != "" {
+		return testMatch(ctx, opts, fetcher, poster)
+	}
+	// calls without context, such as ones of go-redmine, are abandoned on timeout
+	errCh := make(chan error, 1)
+	go func() { errCh <- run(ctx, opts, fetcher, poster) }()
+	select {
+	case err = <-errCh:
+		// the call with context may return first on timeout
+		if err != nil && ctx.Err() == context.DeadlineExceeded {
+			err = errMaxRuntimeExceeded
+		}
+	case <-ctx.Done():
+		err = errMaxRuntimeExceeded
+	}
+	// expired issues are reported, not a failure of the run
+	if err != nil && err != errExpiredIssues && opts.Slack.ErrorChannel != "" && !opts.DryRun {
+		// the notification is tried only once, even if it is Slack which has failed
+		if nerr := notifyError(opts, poster, err); nerr != nil {
+			warnf("failed to notify the error: %s", nerr)
+		}
+	}
+	return err
+}
 
-	return postToSlack(opts, out[0], out[1])
+// notifyError posts the error of the run to the error channel.
+func notifyError(opts options, poster messagePoster, err error) error {
+	// the context of the run may be already done
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	text := fmt.Sprintf("redmine-issue-summary failed at %s: %s", clock().Format(time.RFC3339), errorReason(err))
+	_, perr := poster.PostInThread(ctx, opts.Slack.ErrorChannel, text, "")
+	return perr
 }
 
-func initialize(opts options) error {
-	log.Print("initialize clients")
-	slackClient = slack.New(opts.Slack.Token)
-	if err := loadSlackUsers(); err != nil {
+// quotedURL matches URLs quoted in errors of requests.
+var quotedURL = regexp.MustCompile(` ?"[a-z][a-z0-9+.-]*://[^"]*"`)
+
+// errorReason returns the reason of the error to be posted to Slack.
+// URLs of failed requests are dropped, as they may have credentials,
+// while the full error is left to the log.
+func errorReason(err error) string {
+	var se *httpStatusError
+	if errors.As(err, &se) {
+		return se.Error()
+	}
+	var ue *url.Error
+	if errors.As(err, &ue) {
+		if ue.Timeout() {
+			return "timeout"
+		}
+		return "unreachable"
+	}
+	// errors of requests may be wrapped as text
+	return quotedURL.ReplaceAllString(err.Error(), "")
+}
+
+func run(ctx context.Context, opts options, fetcher issueFetcher, poster messagePoster) error {
+	start := clock()
+	if err := initialize(ctx, opts, fetcher, poster); err != nil {
 		return err
 	}
-	redmineClient = redmine.NewClient(opts.Redmine.Endpoint, opts.Redmine.APIKey)
-	redmineClient.Limit = maxLimit
+	var past []snapshot
+	closedSince = ""
+	if opts.SnapshotFile != "" && opts.Slack.ShowReopened {
+		var err error
+		if past, err = pastSnapshots(opts.SnapshotFile); err != nil {
+			return err
+		}
+		// the issues closed before are recorded in the past snapshots
+		closedSince = today.Format(snapshotDateLayout)
+		if n := len(past); n > 0 {
+			closedSince = past[n-1].Date
+		}
+	}
+	var iss []issue
 	var err error
-	targetProject, err = getProject(opts.Redmine.Project)
+	if opts.Redmine.IssuesFile != "" {
+		iss, err = loadIssues(opts.Redmine)
+	} else {
+		iss, err = getIssues(ctx, fetcher, opts.Redmine)
+	}
 	if err != nil {
 		return err
 	}
-	return loadRedmineUsers()
-}
+	if opts.SnapshotFile != "" {
+		var prev *snapshot
+		if opts.DryRun {
+			prev, err = lastSnapshot(opts.SnapshotFile)
+		} else {
+			prev, err = recordSnapshot(opts.SnapshotFile, append(iss, finishedIssues...))
+		}
+		if err != nil {
+			return err
+		}
+		if opts.Slack.ShowAssigneeChanges {
+			assigneeChanges = diffAssignees(prev, iss)
+		}
+		if opts.Slack.ShowReopened {
+			reopenedIssues = diffReopened(past, iss, finishedStatuses)
+		}
+	} else if opts.Slack.ShowAssigneeChanges || opts.Slack.ShowReopened {
+		return errors.New("snapshot-file is required to compare with the last snapshot")
+	}
 
-func loadUserMap() map[string]string {
-	f, err := os.Open("./usermapping.json")
-	if err != nil {
-		return map[string]string{}
+	if opts.Digest == digestWeekly {
+		err = postDigest(ctx, opts, poster)
+	} else {
+		out := fanout(iss, opts.BucketAssignment == bucketAssignmentFirstMatch, isUndated, isExpired, isNear, isSLABreached)
+		err = postToSlack(ctx, opts, poster, iss, out[1], out[2], out[3], out[0])
 	}
-	defer f.Close()
-	m := map[string]string{}
-	if err := json.NewDecoder(f).Decode(&m); err != nil {
-		return map[string]string{}
+	if opts.Slack.OpsChannel != "" && !opts.DryRun {
+		var errs int
+		if err != nil {
+			errs++
+		}
+		if perr := postRunSummary(ctx, opts, poster, len(iss), count(iss, isExpired), errs, clock().Sub(start)); perr != nil {
+			warnf("failed to post run summary: %s", perr)
+		}
 	}
-	return m
-}
-
-func loadRedmineUsers() error {
-	users, err := redmineClient.Users()
 	if err != nil {
 		return err
 	}
-	for _, user := range users {
-		redmineUsers.Set(user.Id, user)
+	// the suggestions are not written on dry run, which leaves no files
+	if opts.Slack.SuggestUserMap != "" && !opts.DryRun {
+		if err := suggestUserMap(opts.Slack.SuggestUserMap); err != nil {
+			warnf("failed to suggest usermapping: %s", err)
+		}
+	}
+	if len(targetFailures) > 0 {
+		return errPartialFailure
+	}
+	if requireDueDate && opts.Redmine.MaxUndated >= 0 {
+		if n := count(iss, isUndated); n > opts.Redmine.MaxUndated {
+			warnf("issues without due date: %d", n)
+			return errTooManyUndated
+		}
+	}
+	if opts.FailOnExpired && count(iss, isExpired) > 0 {
+		return errExpiredIssues
 	}
 	return nil
 }
 
-func loadSlackUsers() error {
-	users, err := slackClient.Users().List().Do(context.Background())
+func initialize(ctx context.Context, opts options, fetcher issueFetcher, poster messagePoster) error {
+	infof("initialize clients")
+	ignoreNotStarted = opts.Redmine.IgnoreNotStarted
+	requireDueDate = opts.Redmine.RequireDueDate
+	messages = selectMessages(opts.Slack.Lang)
+	var err error
+	if opts.Timezone != "" {
+		location, err = time.LoadLocation(opts.Timezone)
+		if err != nil {
+			return fmt.Errorf("invalid timezone %q: %s", opts.Timezone, err)
+		}
+	}
+	setClock(clock().In(location), opts.Redmine.NearDays)
+	slaWindows, err = parseSLA(opts.Redmine.SLA)
 	if err != nil {
 		return err
 	}
-	slackUsers = users
-	return nil
-}
-
-func getIssues(opts redmineOptions) ([]issue, error) {
-	log.Print("getIssues")
-	cli := redmine.NewClient(opts.Endpoint, opts.APIKey)
-
-	res, err := cli.Issues()
+	nameRules, err = loadNameRules(opts.Slack.NameRules)
 	if err != nil {
-		return nil, err
+		return err
 	}
-
-	log.Printf("issues: %d", len(res))
-	return convertIssues(res, opts), nil
-}
-
-func getProject(target string) (redmine.Project, error) {
-	projects, err := redmineClient.Projects()
+	lineFields, err = parseLineFields(opts.Slack.LineFields)
 	if err != nil {
-		return redmine.Project{}, err
+		return err
 	}
-	for _, project := range projects {
-		if strconv.Itoa(project.Id) == target || project.Name == target {
-			return project, nil
+	if opts.Slack.HideStatus {
+		lineFields = removeField(lineFields, fieldStatus)
+	}
+	nearSplits, err = parseNearSplits(opts.Slack.NearSplit)
+	if err != nil {
+		return err
+	}
+	trackerEmojis, err = parseTrackerEmoji(opts.Slack.TrackerEmoji)
+	if err != nil {
+		return err
+	}
+	richOverdueDays, err = parseOverdueDays(opts.Slack.RichOverdueDays)
+	if err != nil {
+		return err
+	}
+	if err := validateDateLayout(opts.Redmine.DateLayout); err != nil {
+		return err
+	}
+	dateLayout = opts.Redmine.DateLayout
+	if opts.Redmine.Scope != scopeMine && opts.Redmine.Project == "" {
+		return errors.New("redmine-project is required")
+	}
+	if opts.Redmine.IssuesFile != "" && opts.Redmine.MinPriority != "" {
+		return errors.New("min-priority is not supported with issues-file")
+	}
+
+	// users and projects are independent, so they are loaded concurrently
+	var loaders []func() error
+	if opts.Slack.Token != "" {
+		loaders = append(loaders, func() error { return loadSlackUsers(ctx, poster) })
+	} else {
+		// users are not listed by webhook, so mentions fall back to names
+		warnf("post by webhook, @mentions are not resolved")
+		slackUsers = nil
+	}
+	// Redmine is not used in offline mode,
+	// the target project is resolved from the issues in the file.
+	if opts.Redmine.IssuesFile == "" {
+		loaders = append(loaders,
+			func() error { return loadTargetProjects(ctx, fetcher, opts.Redmine) },
+			func() error { return loadRedmineUsers(ctx, fetcher, opts.Redmine) },
+		)
+		if opts.Redmine.IncludeGroupAssigned {
+			loaders = append(loaders, func() error { return loadRedmineGroups(ctx, fetcher, opts.Redmine) })
+		} else {
+			// groups are still loaded to tell them from unresolved users
+			loaders = append(loaders, func() error {
+				if err := loadRedmineGroups(ctx, fetcher, opts.Redmine); err != nil {
+					warnf("failed to load groups, unresolved assignees are not marked: %s", err)
+				}
+				return nil
+			})
+		}
+		if opts.Redmine.MinPriority != "" || opts.Slack.SortWithinGroup == fieldPriority {
+			loaders = append(loaders, func() error { return loadPriorities(ctx, fetcher, opts.Redmine) })
+		}
+		loaders = append(loaders, func() error { return loadFinishedStatuses(ctx, fetcher, opts.Redmine) })
+	} else {
+		finishedStatuses, err = parseStatusIDs(opts.Redmine.FinishedStatus)
+		if err != nil {
+			return err
+		}
+		if opts.Redmine.UsersFile != "" {
+			loaders = append(loaders, func() error { return loadUsersFile(opts.Redmine) })
 		}
 	}
-	return redmine.Project{}, errors.New("project not found")
+	if err := parallel(loaders...); err != nil {
+		return err
+	}
+	allUsersLoaded = opts.Redmine.IssuesFile == "" && redmineGroups != nil
+	return nil
 }
 
-func convertIssues(ris []redmine.Issue, opts redmineOptions) []issue {
-	log.Print("convertIssues")
-	var is []issue
-	for _, ri := range ris {
-		// workaround(1)
-		if ri.Project.Id != targetProject.Id {
-			continue
-		}
+// parallel calls the functions concurrently and returns the first error of them.
+func parallel(fns ...func() error) error {
+	var wg sync.WaitGroup
+	errCh := make(chan error, len(fns))
+	for _, fn := range fns {
+		wg.Add(1)
+		go func(fn func() error) {
+			defer wg.Done()
+			if err := fn(); err != nil {
+				errCh <- err
+			}
+		}(fn)
+	}
+	wg.Wait()
+	close(errCh)
+	return <-errCh
+}
 
-		if in(ri.Status.Id, opts.FinishedStatus) {
+// loadTargetProjects resolves the target projects.
+func loadTargetProjects(ctx context.Context, fetcher issueFetcher, opts redmineOptions) error {
+	if opts.Scope == scopeMine {
+		// issues assigned to me are reported across all projects
+		targetProject = redmine.Project{Name: messages.AllProjects}
+		return nil
+	}
+	targetProjects = nil
+	for _, target := range splitList(opts.Project) {
+		project, err := getProject(ctx, fetcher, opts, target)
+		if err != nil {
+			if !opts.PartialFailureOK {
+				return fmt.Errorf("%s: %s", target, err)
+			}
+			warnf("failed to get project %s: %s", target, err)
+			targetFailures = append(targetFailures, fmt.Sprintf(messages.FetchFailed, target, errorReason(err)))
 			continue
 		}
-
-		due, _ := time.Parse("2006-01-02", ri.DueDate)
-		is = append(is, issue{
-			ID:         ri.Id,
-			Subject:    ri.Subject,
-			DueDate:    due,
-			AssignedTo: ri.AssignedTo,
-		})
+		targetProjects = append(targetProjects, project)
 	}
-	return is
+	if len(targetProjects) == 0 {
+		return errors.New("no project is found")
+	}
+	targetProject = combineProjects(targetProjects)
+	return nil
 }
 
-func in(t int, vs []int) bool {
-	for _, v := range vs {
-		if t == v {
-			return true
+// splitList splits the comma separated list, such as target projects and channels.
+func splitList(s string) []string {
+	var targets []string
+	for _, target := range strings.Split(s, ",") {
+		if target = strings.TrimSpace(target); target != "" {
+			targets = append(targets, target)
 		}
 	}
-	return false
+	return targets
 }
 
-func isExpired(is issue) bool {
-	return today. /*Is*/ After(is.DueDate)
+// combineProjects returns the project representing all target projects in headers.
+// It is the project itself when only one project is given.
+func combineProjects(projects []redmine.Project) redmine.Project {
+	if len(projects) == 1 {
+		return projects[0]
+	}
+	var names []string
+	for _, project := range projects {
+		names = append(names, project.Name)
+	}
+	return redmine.Project{Name: strings.Join(names, "・")}
 }
 
-func isNear(is issue) bool {
-	return !isExpired(is) && weekend. /*Is*/ After(is.DueDate)
+// normalizeEndpoint validates the endpoint URL of Redmine
+// and strips the trailing slash from it.
+func normalizeEndpoint(endpoint string) (string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", fmt.Errorf("invalid redmine endpoint: %s", err)
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return "", fmt.Errorf("invalid redmine endpoint %q: scheme and host are required", endpoint)
+	}
+	u.Path = strings.TrimRight(u.Path, "/")
+	return u.String(), nil
 }
 
-func postToSlack(opts options, expiredCh, nearCh <-chan issue) error {
-	cli := slack.New(opts.Slack.Token)
-	if _, err := cli.Auth().Test().Do(context.Background()); err != nil {
+// testMatch prints whether the redmine user and the slack user given by
+// --test-match are considered as same user, and which rule matched them.
+func testMatch(ctx context.Context, opts options, fetcher issueFetcher, poster messagePoster) error {
+	pair := strings.SplitN(opts.TestMatch, " ", 2)
+	if len(pair) != 2 || pair[1] == "" {
+		return errors.New(`test-match must be formatted as "redmine-login slack-user"`)
+	}
+	if opts.Slack.Token == "" {
+		return errors.New("slack-token is required to test match")
+	}
+	var err error
+	nameRules, err = loadNameRules(opts.Slack.NameRules)
+	if err != nil {
 		return err
 	}
-	var out bytes.Buffer
-	var buf bytes.Buffer
-	var ec int
-	for is := range expiredCh {
-		ec++
-		fmt.Fprintf(&buf, "- %s <%s/issues/%d|#%d>: %s(%s)\n", unassignable(formatTime(is.DueDate), "期日"), opts.Redmine.Endpoint, is.ID, is.ID, is.Subject, unassignable(getUser(opts, is.AssignedTo), "担当"))
+	if err := loadRedmineUsers(ctx, fetcher, opts.Redmine); err != nil {
+		return err
 	}
-	fmt.Fprintf(&out, "%s の期限切れのチケットは *%d件* です\n", targetProject.Name, ec)
-	buf.WriteTo(&out)
-	buf.Reset()
-	var nc int
-	for is := range nearCh {
-		nc++
-		fmt.Fprintf(&buf, "- %s <%s/issues/%d|#%d>: %s(%s)\n", unassignable(formatTime(is.DueDate), "期日"), opts.Redmine.Endpoint, is.ID, is.ID, is.Subject, unassignable(getUser(opts, is.AssignedTo), "担当"))
+	redmineUser, err := redmineUsers.GetByLogin(pair[0])
+	if err != nil {
+		return err
 	}
-	fmt.Fprintf(&out, "%s の期限切れが近いチケットは *%d件* です\n", targetProject.Name, nc)
-	buf.WriteTo(&out)
-	log.Print("post to slack")
-	if _, err := cli.Chat().PostMessage(opts.Slack.Channel).LinkNames(true).Text(out.String()).Do(context.Background()); err != nil {
+	if err := loadSlackUsers(ctx, poster); err != nil {
+		return err
+	}
+	slackUser, err := findSlackUser(pair[1])
+	if err != nil {
 		return err
 	}
+	rule := matchUser(redmineUser, slackUser)
+	if rule == "" {
+		fmt.Printf("%s and %s are not matched\n", pair[0], pair[1])
+		return nil
+	}
+	fmt.Printf("%s and %s are matched by %s\n", pair[0], pair[1], rule)
 	return nil
 }
 
-func unassignable(target, label string) string {
-	if target == "" {
-		return fmt.Sprintf("%s未設定", label)
+// findSlackUser finds the slack user by name, real name, display name or email.
+func findSlackUser(target string) (slackMember, error) {
+	for _, slackUser := range slackUsers {
+		switch target {
+		case slackUser.Name, slackUser.RealName, slackUser.DisplayName, slackUser.Profile.Email:
+			return slackUser, nil
+		}
 	}
-	return target
+	return slackMember{}, fmt.Errorf("slack user not found: %s", target)
 }
 
-func getUser(opts options, idname *redmine.IdName) string {
-	if idname == nil {
-		return ""
+// parseSLA parses SLA windows formatted as "Priority=Days,..."
+// into the map from priority name to days.
+func parseSLA(s string) (map[string]int, error) {
+	m := map[string]int{}
+	if s == "" {
+		return m, nil
 	}
-	redmineUser, err := redmineUsers.Get(idname.Id)
+	for _, w := range strings.Split(s, ",") {
+		kv := strings.SplitN(w, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid sla window: %s", w)
+		}
+		days, err := strconv.Atoi(strings.TrimSpace(kv[1]))
+		if err != nil {
+			return nil, fmt.Errorf("invalid sla days: %s", w)
+		}
+		m[strings.TrimSpace(kv[0])] = days
+	}
+	return m, nil
+}
+
+func loadNameRules(path string) ([]nameRule, error) {
+	if path == "" {
+		return nil, nil
+	}
+	f, err := os.Open(path)
 	if err != nil {
-		log.Printf("%s / %s not found", idname.Id, idname.Name)
-		return idname.Name
+		return nil, err
 	}
-	for _, slackUser := range slackUsers {
-		if isSameUser(redmineUser, *slackUser) {
-			return "<@" + slackUser.ID + ">"
+	defer f.Close()
+	var rules []nameRule
+	if err := json.NewDecoder(f).Decode(&rules); err != nil {
+		return nil, err
+	}
+	for i := range rules {
+		rules[i].re, err = regexp.Compile(rules[i].Pattern)
+		if err != nil {
+			return nil, err
 		}
 	}
-	return idname.Name
+	return rules, nil
 }
 
-func isSameUser(redmineUser redmine.User, slackUser objects.User) bool {
-	realName := strings.Replace(slackUser.RealName, "　", " ", -1)
-	if redmineUser.Login == slackUser.Name {
-		return true
+// normalizeName applies the name rules to the name.
+func normalizeName(name string) string {
+	name = strings.Replace(name, "　", " ", -1)
+	for _, rule := range nameRules {
+		name = rule.re.ReplaceAllString(name, rule.Replacement)
 	}
-	switch realName {
-	case
-		redmineUser.Lastname + redmineUser.Firstname,
-		redmineUser.Lastname + " " + redmineUser.Firstname,
-		redmineUser.Firstname + redmineUser.Lastname,
-		redmineUser.Firstname + " " + redmineUser.Lastname:
+	return name
+}
 
-		return true
+// suggestUserMap appends usermapping entries from the real names of slack users
+// who look like the unmatched redmine users, to be reviewed and promoted into usermapping.json.
+func suggestUserMap(path string) error {
+	suggestions := map[string]string{}
+	if b, err := ioutil.ReadFile(path); err == nil {
+		if err := json.Unmarshal(b, &suggestions); err != nil {
+			return err
+		}
+	} else if !os.IsNotExist(err) {
+		return err
 	}
-	if mappedName, ok := userMap[slackUser.RealName]; ok {
-		slackUser.RealName = mappedName
-		return isSameUser(redmineUser, slackUser)
+	for _, redmineUser := range unmatchedUsers.List() {
+		for _, slackUser := range slackUsers {
+			if _, ok := suggestions[slackUser.RealName]; ok || !isCandidate(redmineUser, slackUser) {
+				continue
+			}
+			suggestions[slackUser.RealName] = redmineUser.Lastname + " " + redmineUser.Firstname
+		}
 	}
-	return false
+	b, err := json.MarshalIndent(suggestions, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, b, 0644)
 }
 
-func formatTime(t time.Time) string {
-	s := t.Format("2006-01-02")
-	if s == "0001-01-01" {
-		return ""
+// isCandidate reports whether the slack user looks like the redmine user,
+// that is, the real name contains the last name or the first name.
+func isCandidate(redmineUser redmine.User, slackUser slackMember) bool {
+	realName := normalizeName(slackUser.RealName)
+	if realName == "" {
+		return false
+	}
+	for _, name := range []string{redmineUser.Lastname, redmineUser.Firstname} {
+		if name = normalizeName(name); name != "" && strings.Contains(realName, name) {
+			return true
+		}
 	}
-	return s
+	return false
 }
 
-func fanout(in []issue, filters ...func(issue) bool) []chan issue {
-	n := len(filters)
-	out := make([]chan issue, n)
-	for i := 0; i < n; i++ {
-		out[i] = make(chan issue, 10)
-	}
+// nearSplit is a sub-section of near issues,
+// which have Days or less days remaining.
+type nearSplit struct {
+	Label string
+	Days  int
+}
 
-	go func(in []issue, out []chan issue) {
-		defer func(out []chan issue) {
-			log.Print("fanout finished")
-			for _, c := range out {
-				close(c)
-			}
-		}(out)
+// parseNearSplits parses sub-sections formatted as "Label=Days,..."
+// and sorts them by days.
+func parseNearSplits(s string) ([]nearSplit, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var splits []nearSplit
+	for _, w := range strings.Split(s, ",") {
+		kv := strings.SplitN(w, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid near split: %s", w)
+		}
+		days, err := strconv.Atoi(strings.TrimSpace(kv[1]))
+		if err != nil {
+			return nil, fmt.Errorf("invalid near split days: %s", w)
+		}
+		splits = append(splits, nearSplit{Label: strings.TrimSpace(kv[0]), Days: days})
+	}
+	sort.SliceStable(splits, func(i, j int) bool {
+		return splits[i].Days < splits[j].Days
+	})
+	return splits, nil
+}
 
-		for _, iss := range in {
-			for i := range out {
-				if filters[i](iss) {
-					out[i] <- iss
-				}
-			}
+// loadUserMap loads usermapping files and merges them,
+// the entries of later files taking precedence.
+// Files which are missing or invalid are skipped.
+func loadUserMap(paths ...string) map[string]string {
+	m := map[string]string{}
+	for _, path := range paths {
+		if path == "" {
+			continue
+		}
+		for k, v := range loadUserMapFile(path) {
+			m[k] = v
 		}
-	}(in, out)
+	}
+	return m
+}
+
+func loadUserMapFile(path string) map[string]string {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	m := map[string]string{}
+	if err := json.NewDecoder(f).Decode(&m); err != nil {
+		return nil
+	}
+	return m
+}
 
-	return out
+func loadRedmineUsers(ctx context.Context, fetcher issueFetcher, opts redmineOptions) error {
+	domainMap, err := parseEmailDomainMap(opts.EmailDomainMap)
+	if err != nil {
+		return err
+	}
+	users, err := getRedmineUsers(ctx, fetcher, opts)
+	if err != nil {
+		return err
+	}
+	for _, user := range users {
+		user.Mail = mapEmailDomain(user.Mail, domainMap)
+		redmineUsers.Set(user.Id, user)
+	}
+	return nil
+}
+
+func parseEmailDomainMap(pairs []string) (map[string]string, error) {
+	m := map[string]string{}
+	for _, pair := range pairs {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || kv[0] == "" || kv[1] == "" {
+			return nil, fmt.Errorf("invalid email domain map: %s", pair)
+		}
+		m[strings.ToLower(kv[0])] = kv[1]
+	}
+	return m, nil
+}
+
+// mapEmailDomain rewrites the domain of the email according to the domain map.
+func mapEmailDomain(email string, domainMap map[string]string) string {
+	i := strings.LastIndex(email, "@")
+	if i < 0 {
+		return email
+	}
+	if domain, ok := domainMap[strings.ToLower(email[i+1:])]; ok {
+		return email[:i+1] + domain
+	}
+	return email
+}
+
+// loadRedmineGroups loads groups, which issues can be assigned to like users.
+func loadRedmineGroups(ctx context.Context, fetcher issueFetcher, opts redmineOptions) error {
+	groups, err := fetcher.FetchGroups(ctx, opts)
+	if err != nil {
+		return err
+	}
+	redmineGroups = map[int]string{}
+	for _, group := range groups {
+		redmineGroups[group.Id] = group.Name
+	}
+	if opts.ExpandGroup {
+		loadGroupMembers(ctx, fetcher, opts, groups)
+	}
+	return nil
+}
+
+// loadGroupMembers loads the members of groups.
+// Groups whose members cannot be loaded are rendered by their names.
+func loadGroupMembers(ctx context.Context, fetcher issueFetcher, opts redmineOptions, groups []redmine.IdName) {
+	groupMembers = map[int][]redmine.IdName{}
+	for _, group := range groups {
+		members, err := fetcher.FetchGroupMembers(ctx, opts, group.Id)
+		if err != nil {
+			warnf("failed to load members of group %s: %s", group.Name, err)
+			continue
+		}
+		groupMembers[group.Id] = members
+	}
+}
+
+// loadFinishedStatuses resolves the finished statuses by IDs or names of statuses on Redmine.
+// Statuses which do not exist are warned, or fail with --strict-finished-status.
+// IDs which do not exist are kept as given.
+func loadFinishedStatuses(ctx context.Context, fetcher issueFetcher, opts redmineOptions) error {
+	targets := splitStatuses(opts.FinishedStatus)
+	if len(targets) == 0 {
+		return nil
+	}
+	statuses, err := fetcher.FetchStatuses(ctx, opts)
+	if err != nil {
+		return err
+	}
+	var ids []int
+	for _, target := range targets {
+		id, found := findStatus(statuses, target)
+		if !found {
+			if opts.StrictFinishedStatus {
+				return fmt.Errorf("finished status not found: %s", target)
+			}
+			warnf("finished status not found: %s", target)
+			if id, err = strconv.Atoi(target); err != nil {
+				continue
+			}
+		}
+		ids = append(ids, id)
+	}
+	finishedStatuses = ids
+	return nil
+}
+
+func findStatus(statuses []redmine.IssueStatus, target string) (int, bool) {
+	for _, status := range statuses {
+		if strconv.Itoa(status.Id) == target || status.Name == target {
+			return status.Id, true
+		}
+	}
+	return 0, false
+}
+
+// parseStatusIDs parses the finished statuses without Redmine, which must be IDs.
+func parseStatusIDs(targets []string) ([]int, error) {
+	var ids []int
+	for _, target := range splitStatuses(targets) {
+		id, err := strconv.Atoi(target)
+		if err != nil {
+			return nil, fmt.Errorf("finished status must be ID with issues-file: %s", target)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// splitStatuses splits the statuses given by repeated flags or comma separated.
+func splitStatuses(targets []string) []string {
+	var statuses []string
+	for _, target := range targets {
+		statuses = append(statuses, splitList(target)...)
+	}
+	return statuses
+}
+
+// loadPriorities loads the ranks of priorities to compare them with the threshold.
+// The ranks are the positions in Redmine, as IDs are not guaranteed to be in order.
+func loadPriorities(ctx context.Context, fetcher issueFetcher, opts redmineOptions) error {
+	priorities, err := fetcher.FetchPriorities(ctx, opts)
+	if err != nil {
+		return err
+	}
+	priorityRanks = map[int]int{}
+	minPriorityRank = -1
+	for rank, priority := range priorities {
+		priorityRanks[priority.Id] = rank
+		if opts.MinPriority != "" && matchIDName(&priority, []string{opts.MinPriority}) {
+			minPriorityRank = rank
+		}
+	}
+	if opts.MinPriority != "" && minPriorityRank < 0 {
+		return fmt.Errorf("priority not found: %s", opts.MinPriority)
+	}
+	return nil
+}
+
+func isBelowMinPriority(priority *redmine.IdName) bool {
+	if priorityRanks == nil || priority == nil {
+		return false
+	}
+	rank, ok := priorityRanks[priority.Id]
+	return ok && rank < minPriorityRank
+}
+
+// slackMember is a user of Slack listed by users.list.
+// The display name is decoded here, as objects.UserProfile does not have it.
+type slackMember struct {
+	objects.User
+	DisplayName string `json:"-"`
+}
+
+func (m *slackMember) UnmarshalJSON(b []byte) error {
+	if err := json.Unmarshal(b, &m.User); err != nil {
+		return err
+	}
+	var profile struct {
+		Profile struct {
+			DisplayName string `json:"display_name"`
+		} `json:"profile"`
+	}
+	if err := json.Unmarshal(b, &profile); err != nil {
+		return err
+	}
+	m.DisplayName = profile.Profile.DisplayName
+	return nil
+}
+
+// loadSlackUsers loads all users of the workspace.
+func loadSlackUsers(ctx context.Context, poster messagePoster) error {
+	users, err := poster.ListUsers(ctx)
+	if err != nil {
+		return err
+	}
+	if len(users) > 0 && !hasEmail(users) {
+		warnf("no email address of Slack users, users:read.email scope may be missing")
+	}
+	slackUsers = users
+	return nil
+}
+
+// hasEmail returns true if any of the users has the email address,
+// which is hidden without users:read.email scope.
+func hasEmail(users []slackMember) bool {
+	for _, user := range users {
+		if user.Profile.Email != "" {
+			return true
+		}
+	}
+	return false
+}
+
+func getIssues(ctx context.Context, fetcher issueFetcher, opts redmineOptions) ([]issue, error) {
+	infof("getIssues")
+	res, err := fetcher.FetchIssues(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	infof("issues: %d", len(res))
+	if opts.DumpIssues != "" {
+		if err := dumpIssues(opts.DumpIssues, res); err != nil {
+			return nil, err
+		}
+	}
+	return convertIssues(res, opts), nil
+}
+
+// getProjectIssues fetches issues of the target project filtered on Redmine,
+// falling back to fetching all issues when Redmine rejects the filter.
+// workaround(1)
+func getProjectIssues(ctx context.Context, opts redmineOptions, params url.Values) ([]redmineIssue, error) {
+	if !opts.ClientSideFilter {
+		var res []redmineIssue
+		var err error
+		var fetched []redmine.Project
+		failed := len(targetFailures)
+		for _, project := range targetProjects {
+			params.Set("project_id", strconv.Itoa(project.Id))
+			var ris []redmineIssue
+			ris, err = getIssuesByQuery(ctx, opts, params)
+			if err != nil && opts.PartialFailureOK {
+				warnf("failed to fetch issues of %s: %s", project.Name, err)
+				targetFailures = append(targetFailures, fmt.Sprintf(messages.FetchFailed, project.Name, errorReason(err)))
+				continue
+			}
+			if err != nil {
+				break
+			}
+			res = append(res, ris...)
+			fetched = append(fetched, project)
+		}
+		if opts.PartialFailureOK && len(fetched) > 0 {
+			// the failed projects are not reported as if they had no issues
+			targetProjects = fetched
+			targetProject = combineProjects(targetProjects)
+			return res, nil
+		}
+		if err == nil {
+			return res, nil
+		}
+		if !isFilterRejected(err) {
+			return nil, err
+		}
+		if opts.QueryID != 0 {
+			// the saved query would be dropped by fetching all issues
+			return nil, err
+		}
+		// all issues are fetched instead, so the projects are not failed
+		targetFailures = targetFailures[:failed]
+		warnf("failed to filter issues by project on redmine, fetch all issues instead: %s", err)
+		params.Del("project_id")
+	}
+	return getAllIssues(ctx, opts, params)
+}
+
+// isFilterRejected reports whether Redmine rejected the project_id filter.
+// Some plugins answer it by 422 or 500 instead of 400.
+// The other errors, such as a timeout or 401/403, would fail fetching all issues as well.
+func isFilterRejected(err error) bool {
+	var se *httpStatusError
+	if !errors.As(err, &se) {
+		return false
+	}
+	switch se.StatusCode {
+	case http.StatusUnauthorized, http.StatusForbidden, http.StatusTooManyRequests:
+		return false
+	case http.StatusInternalServerError:
+		return true
+	}
+	return se.StatusCode >= 400 && se.StatusCode < 500
+}
+
+// getAllIssues fetches issues of all projects, which are filtered by project in convertIssues.
+func getAllIssues(ctx context.Context, opts redmineOptions, params url.Values) ([]redmineIssue, error) {
+	return getIssuesByQuery(ctx, opts, params)
+}
+
+func dumpIssues(path string, ris []redmineIssue) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	return enc.Encode(ris)
+}
+
+// loadIssues loads issues exported from Redmine instead of fetching them, for offline mode.
+func loadIssues(opts redmineOptions) ([]issue, error) {
+	infof("loadIssues")
+	f, err := os.Open(opts.IssuesFile)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var res []redmineIssue
+	if err := json.NewDecoder(f).Decode(&res); err != nil {
+		return nil, err
+	}
+
+	infof("issues: %d", len(res))
+	loadAssignees(res)
+	if opts.Scope == scopeMine {
+		targetProject = redmine.Project{Name: messages.AllProjects}
+	} else {
+		targetProjects = nil
+		for _, target := range splitList(opts.Project) {
+			project, err := findProject(res, target)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s", target, err)
+			}
+			targetProjects = append(targetProjects, project)
+		}
+		targetProject = combineProjects(targetProjects)
+	}
+	return convertIssues(res, opts), nil
+}
+
+// loadUsersFile loads users exported from Redmine for offline mode.
+func loadUsersFile(opts redmineOptions) error {
+	domainMap, err := parseEmailDomainMap(opts.EmailDomainMap)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(opts.UsersFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var users []redmine.User
+	if err := json.NewDecoder(f).Decode(&users); err != nil {
+		return err
+	}
+	for _, user := range users {
+		user.Mail = mapEmailDomain(user.Mail, domainMap)
+		redmineUsers.Set(user.Id, user)
+	}
+	return nil
+}
+
+// loadAssignees adds the assignees of issues not loaded from the users file,
+// so that they are matched with Slack users by name in offline mode.
+// The name is split at the first space, as permutations of the names are matched.
+func loadAssignees(ris []redmineIssue) {
+	for _, ri := range ris {
+		if ri.AssignedTo == nil {
+			continue
+		}
+		if _, err := redmineUsers.Get(ri.AssignedTo.Id); err == nil {
+			continue
+		}
+		names := strings.SplitN(ri.AssignedTo.Name, " ", 2)
+		user := redmine.User{Id: ri.AssignedTo.Id, Firstname: names[0]}
+		if len(names) == 2 {
+			user.Lastname = names[1]
+		}
+		redmineUsers.Set(user.Id, user)
+	}
+}
+
+// findProject finds the project from the projects of issues.
+func findProject(ris []redmineIssue, target string) (redmine.Project, error) {
+	for _, ri := range ris {
+		if matchIDName(ri.Project, []string{target}) {
+			return redmine.Project{Id: ri.Project.Id, Name: ri.Project.Name}, nil
+		}
+	}
+	return redmine.Project{}, errors.New("project not found")
+}
+
+func getWatchedIssues(ctx context.Context, opts redmineOptions, params url.Values) ([]redmineIssue, error) {
+	watcher := opts.Watcher
+	if _, err := strconv.Atoi(watcher); err != nil && watcher != "me" {
+		user, err := redmineUsers.GetByLogin(watcher)
+		if err != nil {
+			return nil, err
+		}
+		watcher = strconv.Itoa(user.Id)
+	}
+	params.Set("watcher_id", watcher)
+	return getIssuesByQuery(ctx, opts, params)
+}
+
+// getIssuesByQuery fetches all pages of issues filtered by given query parameters.
+// mattn/go-redmine does not support arbitrary filters, so this calls Redmine's API directly.
+func getIssuesByQuery(ctx context.Context, opts redmineOptions, params url.Values) ([]redmineIssue, error) {
+	params.Set("limit", strconv.Itoa(maxLimit))
+	if opts.QueryID != 0 {
+		params.Set("query_id", strconv.Itoa(opts.QueryID))
+	}
+	var ris []redmineIssue
+	for {
+		params.Set("offset", strconv.Itoa(len(ris)))
+		var res struct {
+			Issues []redmineIssue `json:"issues"`
+		}
+		if err := getRedmine(ctx, opts, "/issues.json", params, &res); err != nil {
+			var se *httpStatusError
+			if opts.QueryID != 0 && errors.As(err, &se) && se.StatusCode == http.StatusNotFound {
+				return nil, fmt.Errorf("saved query not found: %d", opts.QueryID)
+			}
+			return nil, err
+		}
+		ris = append(ris, res.Issues...)
+		if len(res.Issues) < maxLimit {
+			return ris, nil
+		}
+	}
+}
+
+// getRedmine calls Redmine's API directly and decodes the response into v.
+func getRedmine(ctx context.Context, opts redmineOptions, path string, params url.Values, v interface{}) error {
+	return withRetry(ctx, func() error {
+		req, err := http.NewRequest(http.MethodGet, opts.Endpoint+path+"?"+params.Encode(), nil)
+		if err != nil {
+			return err
+		}
+		resp, err := newRedmineHTTPClient(opts).Do(req.WithContext(ctx))
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return newHTTPStatusError("redmine", resp)
+		}
+		return json.NewDecoder(resp.Body).Decode(v)
+	}, retryAttempts, retryBackoff)
+}
+
+func getProject(ctx context.Context, fetcher issueFetcher, opts redmineOptions, target string) (redmine.Project, error) {
+	projects, err := getRedmineProjects(ctx, fetcher, opts)
+	if err != nil {
+		return redmine.Project{}, err
+	}
+	for _, project := range projects {
+		if strconv.Itoa(project.Id) == target || project.Name == target {
+			return project, nil
+		}
+	}
+	return redmine.Project{}, errors.New("project not found")
+}
+
+func convertIssues(ris []redmineIssue, opts redmineOptions) []issue {
+	infof("convertIssues")
+	var is []issue
+	// the same issue may be fetched twice across pages or projects
+	seen := map[int]bool{}
+	excluded := splitStatuses(opts.ExcludeStatus)
+	for _, ri := range ris {
+		if seen[ri.Id] {
+			debugf("skip #%d: duplicated", ri.Id)
+			continue
+		}
+		seen[ri.Id] = true
+
+		// workaround(1)
+		// this is needed even when filtered on Redmine, to exclude issues of subprojects
+		if opts.Scope != scopeMine && !isTargetProject(ri.Project) {
+			debugf("skip #%d: not in the target projects", ri.Id)
+			continue
+		}
+
+		if !opts.IncludeFinished && in(ri.Status.Id, finishedStatuses) {
+			// kept for snapshots to detect reopened issues
+			finishedIssues = append(finishedIssues, newIssue(ri, opts))
+			debugf("skip #%d: finished", ri.Id)
+			continue
+		}
+
+		if matchIDName(ri.Status, excluded) {
+			debugf("skip #%d: excluded status", ri.Id)
+			continue
+		}
+
+		if len(opts.Author) > 0 && !matchUserIDName(ri.Author, opts.Author) {
+			debugf("skip #%d: not by the authors", ri.Id)
+			continue
+		}
+
+		if len(opts.Tracker) > 0 && !matchIDName(ri.Tracker, opts.Tracker) {
+			debugf("skip #%d: not in the trackers", ri.Id)
+			continue
+		}
+
+		// unassigned issues are never excluded
+		if matchUserIDName(ri.AssignedTo, opts.ExcludeAssignee) {
+			debugf("skip #%d: excluded assignee", ri.Id)
+			continue
+		}
+
+		if isBelowMinPriority(ri.Priority) {
+			debugf("skip #%d: below the minimum priority", ri.Id)
+			continue
+		}
+
+		if opts.Since > 0 && !isUpdatedSince(ri, today.AddDate(0, 0, -opts.Since)) {
+			debugf("skip #%d: not updated since %d days ago", ri.Id, opts.Since)
+			continue
+		}
+
+		debugf("report #%d", ri.Id)
+		is = append(is, newIssue(ri, opts))
+	}
+	return is
+}
+
+// isUpdatedSince reports whether the issue is updated since the cutoff.
+// Issues with unknown update time are considered as updated.
+func isUpdatedSince(ri redmineIssue, cutoff time.Time) bool {
+	updated, err := time.Parse(time.RFC3339, ri.UpdatedOn)
+	if err != nil {
+		return true
+	}
+	return !updated.Before(cutoff)
+}
+
+func isTargetProject(project *redmine.IdName) bool {
+	if project == nil {
+		return false
+	}
+	for _, target := range targetProjects {
+		if project.Id == target.Id {
+			return true
+		}
+	}
+	return false
+}
+
+func newIssue(ri redmineIssue, opts redmineOptions) issue {
+	due, _ := time.ParseInLocation(dateLayout, ri.DueDate, location)
+	start, _ := time.ParseInLocation(dateLayout, ri.StartDate, location)
+	created, _ := time.Parse(time.RFC3339, ri.CreatedOn)
+	var priority string
+	var priorityID int
+	if ri.Priority != nil {
+		priority = ri.Priority.Name
+		priorityID = ri.Priority.Id
+	}
+	var projectID int
+	if ri.Project != nil {
+		projectID = ri.Project.Id
+	}
+	var tracker string
+	if ri.Tracker != nil {
+		tracker = ri.Tracker.Name
+	}
+	var status string
+	var statusID int
+	if ri.Status != nil {
+		status = ri.Status.Name
+		statusID = ri.Status.Id
+	}
+	return issue{
+		ID:          ri.Id,
+		Subject:     ri.Subject,
+		DueDate:     due,
+		StartDate:   start,
+		CreatedOn:   created,
+		Priority:    priority,
+		PriorityID:  priorityID,
+		Status:      status,
+		StatusID:    statusID,
+		Tracker:     tracker,
+		ProjectID:   projectID,
+		Author:      ri.Author,
+		Tags:        customFieldValues(ri, opts.TagsField),
+		CustomField: strings.Join(customFieldValues(ri, opts.CustomField), ", "),
+		AssignedTo:  ri.AssignedTo,
+	}
+}
+
+func count(iss []issue, filter func(issue) bool) int {
+	var n int
+	for _, is := range iss {
+		if filter(is) {
+			n++
+		}
+	}
+	return n
+}
+
+func in(t int, vs []int) bool {
+	for _, v := range vs {
+		if t == v {
+			return true
+		}
+	}
+	return false
+}
+
+// customFieldValues returns values of the custom field specified by ID or name.
+// Values of multiple or comma separated field are split.
+func customFieldValues(ri redmineIssue, field string) []string {
+	for _, cf := range ri.CustomFields {
+		if cf == nil || (strconv.Itoa(cf.Id) != field && cf.Name != field) {
+			continue
+		}
+		var values []string
+		switch v := cf.Value.(type) {
+		case string:
+			for _, value := range strings.Split(v, ",") {
+				if value = strings.TrimSpace(value); value != "" {
+					values = append(values, value)
+				}
+			}
+		case []interface{}:
+			for _, value := range v {
+				if s := fmt.Sprint(value); s != "" {
+					values = append(values, s)
+				}
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+// matchIDName reports whether the id or the name of idname is in targets.
+func matchIDName(idname *redmine.IdName, targets []string) bool {
+	if idname == nil {
+		return false
+	}
+	for _, target := range targets {
+		if strconv.Itoa(idname.Id) == target || idname.Name == target {
+			return true
+		}
+	}
+	return false
+}
+
+// matchUserIDName reports whether the user is in targets,
+// which are given as ID, name or login of users.
+func matchUserIDName(idname *redmine.IdName, targets []string) bool {
+	if idname == nil {
+		return false
+	}
+	if matchIDName(idname, targets) {
+		return true
+	}
+	for _, target := range targets {
+		if u, err := redmineUsers.GetByLogin(target); err == nil && u.Id == idname.Id {
+			return true
+		}
+	}
+	return false
+}
+
+// setClock sets the reference time of the run, and the deadline for near issues days later.
+func setClock(t time.Time, nearDays int) {
+	now = t
+	today = time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	weekend = nearDeadline(today, nearDays)
+}
+
+// nearDeadline returns the deadline for near issues, days later from the day.
+// It is Friday of the week when days is not given.
+func nearDeadline(day time.Time, days int) time.Time {
+	if days <= 0 {
+		return day.Add(time.Duration(5-day.Weekday()) * time.Hour * 24)
+	}
+	return day.Add(time.Duration(days) * time.Hour * 24)
+}
+
+func isExpired(is issue) bool {
+	return isExpiredAt(is, today)
+}
+
+// isExpiredAt reports whether the issue is expired at the day.
+// Issues without due date are never expired, they are undated.
+func isExpiredAt(is issue, day time.Time) bool {
+	return !is.DueDate.IsZero() && isStartedAt(is, day) && day. /*Is*/ After(is.DueDate)
+}
+
+func isNear(is issue) bool {
+	return isNearAt(is, today, weekend)
+}
+
+// isNearAt reports whether the issue is due before the deadline at the day.
+// Issues without due date are never near, they are undated.
+func isNearAt(is issue, day, deadline time.Time) bool {
+	return !is.DueDate.IsZero() && isStartedAt(is, day) && !isExpiredAt(is, day) && deadline. /*Is*/ After(is.DueDate)
+}
+
+// isStartedAt reports whether the issue is started at the day.
+// Every issue is considered as started unless --ignore-not-started is given.
+func isStartedAt(is issue, day time.Time) bool {
+	return !ignoreNotStarted || !is.StartDate.After(day)
+}
+
+// daysOverdue returns the number of days since the due date of the issue at the day.
+func daysOverdue(is issue, day time.Time) int {
+	return int(day.Sub(is.DueDate) / (24 * time.Hour))
+}
+
+// daysRemaining returns the number of days until the due date of the issue at the day.
+func daysRemaining(is issue, day time.Time) int {
+	return int(is.DueDate.Sub(day) / (24 * time.Hour))
+}
+
+// averageOverdue returns the mean of days overdue of the issues with due date.
+// ok is false when no issue has due date.
+func averageOverdue(iss []issue, day time.Time) (avg float64, ok bool) {
+	var sum, n int
+	for _, is := range iss {
+		if is.DueDate.IsZero() {
+			continue
+		}
+		sum += daysOverdue(is, day)
+		n++
+	}
+	if n == 0 {
+		return 0, false
+	}
+	return float64(sum) / float64(n), true
+}
+
+// isUndated reports whether the issue has no due date.
+// Issues are not considered as undated unless --require-duedate is given.
+func isUndated(is issue) bool {
+	return requireDueDate && is.DueDate.IsZero()
+}
+
+// isSLABreached reports whether the issue is older than
+// the SLA window for its priority.
+func isSLABreached(is issue) bool {
+	days, ok := slaWindows[is.Priority]
+	if !ok || is.CreatedOn.IsZero() {
+		return false
+	}
+	return now.Sub(is.CreatedOn) > time.Duration(days)*time.Hour*24
+}
+
+func postToSlack(ctx context.Context, opts options, poster messagePoster, iss, expired, near, sla, undated []issue) error {
+	sortByDueDate(expired)
+	sortByDueDate(near)
+	if opts.HistoryFile != "" {
+		entries, err := loadHistory(opts.HistoryFile)
+		if err != nil {
+			return err
+		}
+		lastWeek = findLastWeek(entries, targetProject.Name)
+	}
+	var errs []error
+	if opts.OutputFile != "" {
+		// the file is written even if posting fails, and vice versa
+		if err := writeMarkdown(opts, iss, expired, near, sla, undated); err != nil {
+			warnf("failed to write the report to %s: %s", opts.OutputFile, err)
+			errs = append(errs, err)
+		}
+	}
+	if err := postReport(ctx, opts, poster, iss, expired, near, sla, undated); err != nil {
+		errs = append(errs, err)
+	} else if opts.HistoryFile != "" && !opts.DryRun {
+		// counts are recorded only when posted, to be compared next week
+		if err := recordHistory(opts.HistoryFile, targetProject.Name, len(expired), len(near)); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return joinErrors(errs)
+}
+
+// postReport renders the report and posts it to Slack.
+func postReport(ctx context.Context, opts options, poster messagePoster, iss, expired, near, sla, undated []issue) error {
+	var postAt time.Time
+	if opts.Slack.PostAt != "" {
+		var err error
+		postAt, err = time.Parse(time.RFC3339, opts.Slack.PostAt)
+		if err != nil {
+			return err
+		}
+	}
+	rs, err := newRenderState(opts)
+	if err != nil {
+		return err
+	}
+	rep := renderReport(opts, rs, iss, expired, near, sla, undated)
+	if len(expired) == 0 && len(near) == 0 {
+		if opts.Slack.SkipEmpty {
+			infof("no expired or near issues, skip posting")
+			return nil
+		}
+		if opts.Slack.AllClearMessage != "" {
+			rep = report{Text: opts.Slack.AllClearMessage + "\n", Heads: opts.Slack.AllClearMessage + "\n"}
+		}
+	}
+	if opts.DryRun {
+		fmt.Print(rep.Text)
+		return nil
+	}
+	if opts.Slack.WebhookURL != "" {
+		return postWebhook(ctx, poster, opts.Slack.WebhookURL, rep.Text)
+	}
+	// the payload is posted once, as the workflow is not bound to the channels
+	if opts.Slack.WorkflowWebhook != "" && postAt.IsZero() {
+		return postWorkflowPayload(ctx, opts, poster, expired, near)
+	}
+	err = withRetry(ctx, func() error {
+		return poster.Test(ctx)
+	}, retryAttempts, retryBackoff)
+	if err != nil {
+		return err
+	}
+	// a failure on a channel does not prevent posting to the others
+	var errs []error
+	var posted []postedMessage
+	for _, channel := range splitList(opts.Slack.Channel) {
+		opts.Slack.Channel = channel
+		pm, err := postReportToChannel(ctx, opts, poster, rs, rep, postAt, expired, near)
+		if err != nil {
+			warnf("failed to post to %s: %s", channel, err)
+			errs = append(errs, fmt.Errorf("%s: %s", channel, err))
+			continue
+		}
+		if pm.TS != "" {
+			posted = append(posted, pm)
+		}
+	}
+	// old reports are deleted once, after posting to all channels
+	if opts.Slack.MaxMessageAge > 0 && len(posted) > 0 {
+		if err := rotateMessages(ctx, opts, poster, posted); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return joinErrors(errs)
+}
+
+// postReportToChannel posts the rendered report to the channel of the options.
+// The posted message is returned to be rotated, unless it is scheduled or posted in threads.
+func postReportToChannel(ctx context.Context, opts options, poster messagePoster, rs renderState, rep report, postAt time.Time, expired, near []issue) (postedMessage, error) {
+	out, heads, breaks := rep.Text, rep.Heads, rep.Breaks
+	if !postAt.IsZero() {
+		infof("schedule to post to slack at %s", postAt)
+		id, err := poster.ScheduleMessage(ctx, opts.Slack.Channel, out, postAt)
+		if err != nil {
+			return postedMessage{}, err
+		}
+		infof("scheduled_message_id: %s", id)
+		return postedMessage{}, nil
+	}
+	if opts.Slack.ThreadByAssignee {
+		return postedMessage{}, postThreadByAssignee(ctx, opts, poster, rs, heads, expired)
+	}
+	if opts.Slack.ThreadNear && len(breaks) > 0 {
+		return postedMessage{}, postNearInThread(ctx, opts, poster, out[:breaks[0]], out[breaks[0]:])
+	}
+	infof("post to slack")
+	if opts.Slack.BlockKit || opts.Slack.Format != formatText {
+		post := func() (postedMessage, error) {
+			if opts.Slack.Format == formatAttachment {
+				return poster.PostAttachment(ctx, opts.Slack.Channel, newAttachment(rep))
+			}
+			blocks := newBlocks(out, breaks)
+			if opts.Slack.RichPerIssue {
+				blocks = newRichBlocks(opts, rs, rep, expired, near)
+			}
+			return poster.PostBlocks(ctx, opts.Slack.Channel, out, blocks)
+		}
+		pm, err := post()
+		if err != nil && isNotInChannel(err) {
+			if err := joinChannel(ctx, poster, opts.Slack.Channel); err != nil {
+				return postedMessage{}, err
+			}
+			pm, err = post()
+		}
+		return pm, err
+	}
+	asUser := opts.Slack.PostAsUser
+	if asUser && !strings.HasPrefix(opts.Slack.Token, "xoxp-") {
+		warnf("post-as-user requires a user token, fall back to post as bot")
+		asUser = false
+	}
+	var pm postedMessage
+	if asUser {
+		var err error
+		pm, err = poster.PostMessage(ctx, opts.Slack.Channel, out, true)
+		if err != nil {
+			warnf("failed to post as user, fall back to post as bot: %s", err)
+			pm = postedMessage{}
+		}
+	}
+	if pm.TS == "" {
+		post := func() (err error) {
+			pm, err = poster.PostMessage(ctx, opts.Slack.Channel, out, false)
+			return err
+		}
+		err := withRetryUnsent(ctx, post, retryAttempts, retryBackoff)
+		if err != nil && isNotInChannel(err) {
+			if err := joinChannel(ctx, poster, opts.Slack.Channel); err != nil {
+				return postedMessage{}, err
+			}
+			err = withRetryUnsent(ctx, post, retryAttempts, retryBackoff)
+		}
+		if err != nil {
+			return postedMessage{}, err
+		}
+	}
+	return pm, nil
+}
+
+// renderState is the state of rendering derived in the run, which is not given by the options.
+type renderState struct {
+	// Markdown is set to render the report in Markdown instead of Slack's syntax.
+	Markdown bool
+	// NoMention is set to render names instead of mentions.
+	NoMention bool
+	// Failures is the notes of the projects failed to be fetched.
+	Failures []string
+
+	// Today is the day of the run, and Weekend is the deadline for near issues.
+	Today   time.Time
+	Weekend time.Time
+	// Project is the combined one of Projects, the target projects to group issues by.
+	Project  redmine.Project
+	Projects []redmine.Project
+	// LastWeek is the counts of the last week to be compared with, nil when unknown.
+	LastWeek *historyEntry
+	// Reopened is the issues reopened since the last snapshot, with --show-reopened.
+	Reopened []issue
+	// AssigneeChanges is the previous assignees of issues, with --show-assignee-changes.
+	AssigneeChanges map[int]*redmine.IdName
+	NearSplits      []nearSplit
+	SLAWindows      map[string]int
+	RequireDueDate  bool
+	LineFields      []string
+	DateLayout      string
+	TrackerEmojis   map[string]string
+	// Footer is the footer message with the placeholders replaced.
+	Footer string
+}
+
+// newRenderState returns the state of rendering derived in the run so far.
+func newRenderState(opts options) (renderState, error) {
+	footer, err := loadFooter(opts.Slack)
+	if err != nil {
+		return renderState{}, err
+	}
+	return renderState{
+		Failures:        targetFailures,
+		Today:           today,
+		Weekend:         weekend,
+		Project:         targetProject,
+		Projects:        targetProjects,
+		LastWeek:        lastWeek,
+		Reopened:        reopenedIssues,
+		AssigneeChanges: assigneeChanges,
+		NearSplits:      nearSplits,
+		SLAWindows:      slaWindows,
+		RequireDueDate:  requireDueDate,
+		LineFields:      lineFields,
+		DateLayout:      dateLayout,
+		TrackerEmojis:   trackerEmojis,
+		Footer:          footer,
+	}, nil
+}
+
+// report is the report rendered from the issues.
+type report struct {
+	Text string
+	// Heads is the header lines of the report without issues.
+	Heads string
+	// SectionHeads is the header lines of the expired and the near sections.
+	SectionHeads []string
+	// Breaks is the offsets in Text where the expired and the near sections end.
+	Breaks []int
+	// Expired and Near are the counts of issues in the sections.
+	Expired, Near int
+}
+
+// renderReport renders the report of the issues in each section, headed by the project.
+// It posts nothing, so that the same text is used for Slack, Markdown and dry-run.
+func renderReport(opts options, rs renderState, iss, expired, near, sla, undated []issue) report {
+	var scope string
+	switch opts.Redmine.Scope {
+	case scopeWatched:
+		scope = messages.ScopeWatched
+	case scopeMine:
+		scope = messages.ScopeMine
+	}
+	var out bytes.Buffer
+	var heads bytes.Buffer
+	head := io.MultiWriter(&out, &heads)
+	var buf bytes.Buffer
+	ec := len(expired)
+	for _, failure := range rs.Failures {
+		fmt.Fprint(head, failure)
+	}
+	var sectionHeads []string
+	start := heads.Len()
+	for _, g := range groupByProject(rs.Project, rs.Projects, expired) {
+		fmt.Fprintf(head, messages.ExpiredHead, g.Project.Name, scope, len(g.Issues))
+		if opts.Slack.ShowAverageOverdue {
+			if avg, ok := averageOverdue(g.Issues, rs.Today); ok {
+				fmt.Fprintf(head, messages.AverageOverdue, avg)
+			}
+		}
+		fmt.Fprint(head, "\n")
+	}
+	sectionHeads = append(sectionHeads, heads.String()[start:])
+	switch {
+	case opts.Slack.Sample > 0 && len(expired) > opts.Slack.Sample:
+		writeIssuesUpTo(&buf, opts, rs, sampleIssues(expired, opts.Slack.Sample, rs.Today.Unix()), opts.Slack.Limit)
+		fmt.Fprintf(&buf, messages.SampleNote, len(expired), opts.Slack.Sample)
+	case opts.Slack.GroupByAssignee:
+		writeByAssignee(&buf, opts, rs, expired, opts.Slack.Limit)
+	default:
+		writeIssuesUpTo(&buf, opts, rs, expired, opts.Slack.Limit)
+	}
+	buf.WriteTo(&out)
+	breaks := []int{out.Len()}
+	buf.Reset()
+	nearRS := rs
+	nearRS.NoMention = rs.NoMention || opts.Slack.MentionExpiredOnly
+	start = heads.Len()
+	for _, g := range groupByProject(rs.Project, rs.Projects, near) {
+		fmt.Fprintf(head, messages.NearHead, g.Project.Name, scope, len(g.Issues))
+	}
+	sectionHeads = append(sectionHeads, heads.String()[start:])
+	switch {
+	case len(rs.NearSplits) > 0:
+		writeNearSplits(&buf, opts, nearRS, near, opts.Slack.Limit)
+	case opts.Slack.GroupByAssignee:
+		writeByAssignee(&buf, opts, nearRS, near, opts.Slack.Limit)
+	default:
+		writeIssuesUpTo(&buf, opts, nearRS, near, opts.Slack.Limit)
+	}
+	buf.WriteTo(&out)
+	breaks = append(breaks, out.Len())
+	buf.Reset()
+	for _, is := range sla {
+		writeIssue(&buf, opts, rs, is)
+	}
+	if len(rs.SLAWindows) > 0 {
+		fmt.Fprintf(head, messages.SLAHead, rs.Project.Name, scope, len(sla))
+		buf.WriteTo(&out)
+	}
+	if opts.Slack.ShowReopened {
+		buf.Reset()
+		for _, is := range rs.Reopened {
+			writeIssue(&buf, opts, rs, is)
+		}
+		fmt.Fprintf(head, messages.ReopenedHead, rs.Project.Name, scope, len(rs.Reopened))
+		buf.WriteTo(&out)
+	}
+	buf.Reset()
+	for _, is := range undated {
+		writeIssue(&buf, opts, rs, is)
+	}
+	if rs.RequireDueDate {
+		fmt.Fprintf(head, messages.UndatedHead, rs.Project.Name, scope, len(undated))
+		buf.WriteTo(&out)
+	}
+	if opts.Redmine.ShowStatusBreakdown {
+		fmt.Fprintf(head, messages.StatusBreakdownHead, rs.Project.Name, scope)
+		writeStatusBreakdown(head, iss)
+	}
+	if opts.Slack.ShowOverdueRate {
+		if len(iss) == 0 {
+			fmt.Fprintf(head, messages.NoOpenIssues, rs.Project.Name, scope)
+		} else {
+			fmt.Fprintf(head, messages.OverdueRate, rs.Project.Name, scope, float64(ec)*100/float64(len(iss)), ec, len(iss))
+		}
+	}
+	if opts.Slack.RollupByAssignee {
+		out.Reset()
+		out.Write(heads.Bytes())
+		breaks = nil
+		writeRollup(head, opts, rs, expired, near)
+	}
+	if opts.Slack.ShowTotals {
+		fmt.Fprintf(head, messages.Totals, len(iss), len(expired), len(near))
+	}
+	if rs.LastWeek != nil {
+		fmt.Fprintf(head, messages.WeekOverWeek, len(expired)-rs.LastWeek.Expired, len(near)-rs.LastWeek.Near)
+	}
+	io.WriteString(head, rs.Footer)
+	return report{Text: out.String(), Heads: heads.String(), SectionHeads: sectionHeads, Breaks: breaks, Expired: len(expired), Near: len(near)}
+}
+
+// sortByDueDate sorts issues by due date ascending, then by ID.
+// Issues without due date are sorted to the end.
+func sortByDueDate(iss []issue) {
+	sort.Slice(iss, func(i, j int) bool {
+		a, b := iss[i], iss[j]
+		if a.DueDate.IsZero() != b.DueDate.IsZero() {
+			return b.DueDate.IsZero()
+		}
+		if !a.DueDate.Equal(b.DueDate) {
+			return a.DueDate.Before(b.DueDate)
+		}
+		return a.ID < b.ID
+	})
+}
+
+// projectIssues is issues of a target project.
+type projectIssues struct {
+	Project redmine.Project
+	Issues  []issue
+}
+
+// groupByProject groups issues by the target projects, in the order the projects are given.
+// All issues are in one group of the combined project unless multiple projects are given.
+func groupByProject(combined redmine.Project, projects []redmine.Project, iss []issue) []projectIssues {
+	if len(projects) <= 1 {
+		return []projectIssues{{Project: combined, Issues: iss}}
+	}
+	var gs []projectIssues
+	for _, project := range projects {
+		g := projectIssues{Project: project}
+		for _, is := range iss {
+			if is.ProjectID == project.Id {
+				g.Issues = append(g.Issues, is)
+			}
+		}
+		gs = append(gs, g)
+	}
+	return gs
+}
+
+// workflowPayload is the report for Workflow Builder webhook.
+type workflowPayload struct {
+	Project      string          `json:"project"`
+	ExpiredCount int             `json:"expired_count"`
+	NearCount    int             `json:"near_count"`
+	Expired      []workflowIssue `json:"expired"`
+	Near         []workflowIssue `json:"near"`
+}
+
+type workflowIssue struct {
+	ID       int    `json:"id"`
+	Subject  string `json:"subject"`
+	DueDate  string `json:"due_date"`
+	Assignee string `json:"assignee"`
+	URL      string `json:"url"`
+}
+
+func newWorkflowIssues(opts options, iss []issue) []workflowIssue {
+	wis := []workflowIssue{}
+	for _, is := range iss {
+		var assignee string
+		if is.AssignedTo != nil {
+			assignee = is.AssignedTo.Name
+		}
+		wis = append(wis, workflowIssue{
+			ID:       is.ID,
+			Subject:  is.Subject,
+			DueDate:  formatTime(is.DueDate, dateLayout),
+			Assignee: assignee,
+			URL:      fmt.Sprintf("%s/issues/%d", opts.Redmine.Endpoint, is.ID),
+		})
+	}
+	return wis
+}
+
+// postWorkflowPayload posts the report as JSON to the Workflow Builder webhook.
+func postWorkflowPayload(ctx context.Context, opts options, poster messagePoster, expired, near []issue) error {
+	payload := workflowPayload{
+		Project:      targetProject.Name,
+		ExpiredCount: len(expired),
+		NearCount:    len(near),
+		Expired:      newWorkflowIssues(opts, expired),
+		Near:         newWorkflowIssues(opts, near),
+	}
+	infof("post to workflow webhook")
+	if err := poster.PostWebhook(ctx, opts.Slack.WorkflowWebhook, payload); err != nil {
+		return fmt.Errorf("workflow webhook: %s", err)
+	}
+	return nil
+}
+
+// postWebhook posts the text to the incoming webhook URL.
+func postWebhook(ctx context.Context, poster messagePoster, webhookURL, text string) error {
+	infof("post to slack webhook")
+	payload := map[string]interface{}{"text": text, "link_names": true}
+	if err := poster.PostWebhook(ctx, webhookURL, payload); err != nil {
+		return fmt.Errorf("slack webhook: %s", err)
+	}
+	return nil
+}
+
+// postText posts the text to the channel as is.
+func postText(ctx context.Context, opts options, poster messagePoster, text string) error {
+	if opts.DryRun {
+		fmt.Print(text)
+		return nil
+	}
+	if opts.Slack.WebhookURL != "" {
+		return postWebhook(ctx, poster, opts.Slack.WebhookURL, text)
+	}
+	if err := poster.Test(ctx); err != nil {
+		return err
+	}
+	var errs []error
+	for _, channel := range splitList(opts.Slack.Channel) {
+		if _, err := poster.PostMessage(ctx, channel, text, false); err != nil {
+			warnf("failed to post to %s: %s", channel, err)
+			errs = append(errs, fmt.Errorf("%s: %s", channel, err))
+		}
+	}
+	return joinErrors(errs)
+}
+
+// postThreadByAssignee posts the counts of issues, then replies the expired issues
+// of each assignee in its thread.
+func postThreadByAssignee(ctx context.Context, opts options, poster messagePoster, rs renderState, heads string, expired []issue) error {
+	infof("post to slack")
+	parent, err := poster.PostInThread(ctx, opts.Slack.Channel, heads, "")
+	if err != nil {
+		return err
+	}
+	ts := parent.TS
+	// assignees are in the order of their first issue, sorted by due date, unless --sort-groups is given
+	var assignees []string
+	groups := map[string][]issue{}
+	for _, is := range expired {
+		assignee := unassignable(getUser(opts, rs, is.AssignedTo), messages.LabelAssignee)
+		if _, ok := groups[assignee]; !ok {
+			assignees = append(assignees, assignee)
+		}
+		groups[assignee] = append(groups[assignee], is)
+	}
+	for _, group := range groups {
+		sortWithinGroup(group, opts.Slack.SortWithinGroup)
+	}
+	sortGroups(assignees, func(assignee string) int { return len(groups[assignee]) }, opts.Slack.SortGroups)
+	infof("reply to thread %s", ts)
+	for _, assignee := range assignees {
+		var buf bytes.Buffer
+		if opts.Slack.HideGroupCounts {
+			fmt.Fprintf(&buf, messages.ThreadHead, assignee)
+		} else {
+			fmt.Fprintf(&buf, messages.ThreadHeadCount, assignee, len(groups[assignee]))
+		}
+		for _, is := range groups[assignee] {
+			writeIssue(&buf, opts, rs, is)
+		}
+		if _, err := poster.PostInThread(ctx, opts.Slack.Channel, buf.String(), ts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// postNearInThread posts the expired section, then replies the rest of the report in its thread.
+func postNearInThread(ctx context.Context, opts options, poster messagePoster, expired, rest string) error {
+	infof("post to slack")
+	parent, err := poster.PostInThread(ctx, opts.Slack.Channel, expired, "")
+	if err != nil {
+		return err
+	}
+	ts := parent.TS
+	infof("reply to thread %s", ts)
+	if _, err := poster.PostInThread(ctx, opts.Slack.Channel, rest, ts); err != nil {
+		// the parent is kept, so the reply can be retried to the thread
+		warnf("failed to reply to thread %s of %s", ts, opts.Slack.Channel)
+		return err
+	}
+	return nil
+}
+
+// loadFooter returns the footer message with the placeholders replaced.
+func loadFooter(opts slackOptions) (string, error) {
+	footer := opts.Footer
+	if footer == "" && opts.FooterFile != "" {
+		b, err := ioutil.ReadFile(opts.FooterFile)
+		if err != nil {
+			return "", err
+		}
+		footer = string(b)
+	}
+	return strings.Replace(footer, "{date}", today.Format("2006-01-02"), -1), nil
+}
+
+func isNotInChannel(err error) bool {
+	if e, ok := err.(*slackAPIError); ok {
+		return e.Code == "not_in_channel"
+	}
+	return strings.Contains(err.Error(), "not_in_channel")
+}
+
+// joinChannel joins the public channel given by its ID or name.
+// Bots cannot join private channels by themselves, so they have to be invited.
+func joinChannel(ctx context.Context, poster messagePoster, channel string) error {
+	channels, err := poster.ListChannels(ctx, "public_channel")
+	if err != nil {
+		return err
+	}
+	c, ok := findChannel(channels, channel)
+	if !ok {
+		return fmt.Errorf("not in channel %s: invite the bot to the channel by /invite if it is private", channel)
+	}
+	infof("join %s", channel)
+	return poster.JoinChannel(ctx, c.ID)
+}
+
+// findChannel finds the channel by its ID or name.
+func findChannel(channels []slackChannel, channel string) (slackChannel, bool) {
+	name := strings.TrimPrefix(channel, "#")
+	for _, c := range channels {
+		if c.ID == channel || c.Name == name {
+			return c, true
+		}
+	}
+	return slackChannel{}, false
+}
+
+// slackAPIError is an error returned from Slack Web API.
+type slackAPIError struct {
+	Method string
+	Code   string
+}
+
+func (e *slackAPIError) Error() string {
+	return e.Method + ": " + e.Code
+}
+
+// nonIdempotentSlackMethods are the methods of Slack Web API which post a message on each call.
+var nonIdempotentSlackMethods = map[string]bool{
+	"chat.postMessage":     true,
+	"chat.scheduleMessage": true,
+}
+
+// call calls Slack Web API method directly and decodes the response into v.
+func (p *slackPoster) call(ctx context.Context, method string, params url.Values, v interface{}) error {
+	retry := withRetry
+	if nonIdempotentSlackMethods[method] {
+		retry = withRetryUnsent
+	}
+	return retry(ctx, func() error {
+		req, err := http.NewRequest(http.MethodPost, p.endpoint+method, strings.NewReader(params.Encode()))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Authorization", "Bearer "+p.token)
+		resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode == http.StatusTooManyRequests {
+			return newHTTPStatusError("slack", resp)
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return err
+		}
+		var status struct {
+			OK    bool   `json:"ok"`
+			Error string `json:"error"`
+		}
+		if err := json.Unmarshal(body, &status); err != nil {
+			return err
+		}
+		if !status.OK {
+			return &slackAPIError{Method: method, Code: status.Error}
+		}
+		if v == nil {
+			return nil
+		}
+		return json.Unmarshal(body, v)
+	}, retryAttempts, retryBackoff)
+}
+
+// postRunSummary posts a terse health message of the run to the ops channel.
+func postRunSummary(ctx context.Context, opts options, poster messagePoster, issues, expired, errs int, elapsed time.Duration) error {
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "Redmine summary ran: %d issues, %d expired, %d errors, %.1fs\n", issues, expired, errs, elapsed.Seconds())
+	for _, w := range warnings.List() {
+		fmt.Fprintf(&out, "- warning: %s\n", w)
+	}
+	infof("post run summary to slack")
+	_, err := poster.PostMessage(ctx, opts.Slack.OpsChannel, out.String(), false)
+	return err
+}
+
+// writeIssuesUpTo writes at most limit issues and the count of the rest.
+// Issues are expected to be sorted so that the urgent ones are written.
+func writeIssuesUpTo(w io.Writer, opts options, rs renderState, iss []issue, limit int) {
+	for i, is := range iss {
+		if limit > 0 && i >= limit {
+			fmt.Fprintf(w, messages.LimitNote, len(iss)-limit)
+			return
+		}
+		writeIssue(w, opts, rs, is)
+	}
+}
+
+// sampleIssues returns n issues chosen deterministically by the seed, keeping their order.
+func sampleIssues(iss []issue, n int, seed int64) []issue {
+	idx := rand.New(rand.NewSource(seed)).Perm(len(iss))[:n]
+	sort.Ints(idx)
+	sampled := make([]issue, n)
+	for i, j := range idx {
+		sampled[i] = iss[j]
+	}
+	return sampled
+}
+
+// writeNearSplits writes near issues in the sub-sections by days remaining,
+// at most limit issues in total as writeIssuesUpTo.
+// Issues beyond all sub-sections are written in the last "その他" section.
+func writeNearSplits(w io.Writer, opts options, rs renderState, near []issue, limit int) {
+	groups := make([][]issue, len(rs.NearSplits)+1)
+	for _, is := range near {
+		d := daysRemaining(is, rs.Today)
+		i := sort.Search(len(rs.NearSplits), func(i int) bool { return d <= rs.NearSplits[i].Days })
+		groups[i] = append(groups[i], is)
+	}
+	var written int
+	sep := ""
+	for i, group := range groups {
+		if len(group) == 0 {
+			continue
+		}
+		label := messages.Others
+		if i < len(rs.NearSplits) {
+			label = rs.NearSplits[i].Label
+		}
+		fmt.Fprint(w, sep)
+		sep = groupSeparator(opts.Slack.GroupSeparator)
+		writeGroupHead(w, opts, label, len(group))
+		if limit > 0 && len(group) > limit-written {
+			group = group[:limit-written]
+		}
+		for _, is := range group {
+			writeIssue(w, opts, rs, is)
+		}
+		if written += len(group); limit > 0 && written >= limit {
+			break
+		}
+	}
+	if written < len(near) {
+		fmt.Fprintf(w, messages.LimitNote, len(near)-written)
+	}
+}
+
+// groupByAssignee groups issues by the mention of the assignee, sorted by --sort-within-group in each group.
+// Issues without assignee are grouped by the empty string.
+func groupByAssignee(opts options, rs renderState, iss []issue) map[string][]issue {
+	groups := map[string][]issue{}
+	for _, is := range iss {
+		assignee := getUser(opts, rs, is.AssignedTo)
+		groups[assignee] = append(groups[assignee], is)
+	}
+	for _, group := range groups {
+		sortWithinGroup(group, opts.Slack.SortWithinGroup)
+	}
+	return groups
+}
+
+// sortWithinGroup sorts issues of a group by due date, by priority descending or by ID.
+// Issues of the same priority are sorted by due date.
+func sortWithinGroup(iss []issue, by string) {
+	switch by {
+	case fieldPriority:
+		sortByDueDate(iss)
+		sort.SliceStable(iss, func(i, j int) bool { return priorityRank(iss[i]) > priorityRank(iss[j]) })
+	case fieldID:
+		sort.Slice(iss, func(i, j int) bool { return iss[i].ID < iss[j].ID })
+	default:
+		sortByDueDate(iss)
+	}
+}
+
+// priorityRank returns the position of the priority on Redmine,
+// or its ID when the priorities are not loaded such as with --issues-file.
+func priorityRank(is issue) int {
+	if rank, ok := priorityRanks[is.PriorityID]; ok {
+		return rank
+	}
+	return is.PriorityID
+}
+
+// writeByAssignee writes issues under each assignee, with issues without assignee at the end.
+// At most limit issues are written in total as writeIssuesUpTo.
+func writeByAssignee(w io.Writer, opts options, rs renderState, iss []issue, limit int) {
+	groups := groupByAssignee(opts, rs, iss)
+	var assignees []string
+	for assignee := range groups {
+		if assignee != "" {
+			assignees = append(assignees, assignee)
+		}
+	}
+	by := opts.Slack.SortGroups
+	if by == "" {
+		by = sortGroupsByName
+	}
+	sortGroups(assignees, func(assignee string) int { return len(groups[assignee]) }, by)
+	if _, ok := groups[""]; ok {
+		assignees = append(assignees, "")
+	}
+	var written int
+	for i, assignee := range assignees {
+		group := groups[assignee]
+		if i > 0 {
+			fmt.Fprint(w, groupSeparator(opts.Slack.GroupSeparator))
+		}
+		writeGroupHead(w, opts, unassignable(assignee, messages.LabelAssignee), len(group))
+		if limit > 0 && len(group) > limit-written {
+			group = group[:limit-written]
+		}
+		for _, is := range group {
+			writeIssue(w, opts, rs, is)
+		}
+		if written += len(group); limit > 0 && written >= limit {
+			break
+		}
+	}
+	if written < len(iss) {
+		fmt.Fprintf(w, messages.LimitNote, len(iss)-written)
+	}
+}
+
+// sortGroups sorts the labels of groups by name, or by count descending then by name.
+// The order is kept when by is empty.
+func sortGroups(labels []string, count func(string) int, by string) {
+	switch by {
+	case sortGroupsByName:
+		sort.Strings(labels)
+	case sortGroupsByCount:
+		sort.SliceStable(labels, func(i, j int) bool {
+			if ci, cj := count(labels[i]), count(labels[j]); ci != cj {
+				return ci > cj
+			}
+			return labels[i] < labels[j]
+		})
+	}
+}
+
+// writeGroupHead writes the label of a group of issues, with the count unless it is hidden.
+func writeGroupHead(w io.Writer, opts options, label string, n int) {
+	if opts.Slack.HideGroupCounts {
+		fmt.Fprintf(w, messages.GroupHead, label)
+		return
+	}
+	fmt.Fprintf(w, messages.GroupCount, label, n)
+}
+
+// groupSeparator returns the line put between groups by --group-separator.
+func groupSeparator(sep string) string {
+	switch sep {
+	case "":
+		return ""
+	case groupSeparatorBlank:
+		return "\n"
+	case groupSeparatorDivider:
+		return "――――――――――\n"
+	}
+	return sep + "\n"
+}
+
+type assigneeCount struct {
+	Assignee string
+	Expired  int
+	Near     int
+}
+
+func (ac assigneeCount) Total() int {
+	return ac.Expired + ac.Near
+}
+
+// writeRollup writes a line of the counts of expired and near issues per assignee,
+// ordered by the total count descending unless --sort-groups is given.
+func writeRollup(w io.Writer, opts options, rs renderState, expired, near []issue) {
+	m := map[string]*assigneeCount{}
+	var acs []*assigneeCount
+	countOf := func(is issue) *assigneeCount {
+		assignee := unassignable(getUser(opts, rs, is.AssignedTo), messages.LabelAssignee)
+		ac, ok := m[assignee]
+		if !ok {
+			ac = &assigneeCount{Assignee: assignee}
+			m[assignee] = ac
+			acs = append(acs, ac)
+		}
+		return ac
+	}
+	for _, is := range expired {
+		countOf(is).Expired++
+	}
+	for _, is := range near {
+		countOf(is).Near++
+	}
+	by := opts.Slack.SortGroups
+	if by == "" {
+		by = sortGroupsByCount
+	}
+	assignees := make([]string, len(acs))
+	for i, ac := range acs {
+		assignees[i] = ac.Assignee
+	}
+	sortGroups(assignees, func(assignee string) int { return m[assignee].Total() }, by)
+	for _, assignee := range assignees {
+		ac := m[assignee]
+		fmt.Fprintf(w, messages.RollupLine, ac.Assignee, ac.Expired, ac.Near)
+	}
+}
+
+type statusCount struct {
+	Status string
+	Count  int
+}
+
+// writeStatusBreakdown writes the number of issues per status
+// in a line, ordered by the number descending.
+func writeStatusBreakdown(w io.Writer, iss []issue) {
+	m := map[string]int{}
+	for _, is := range iss {
+		m[is.Status]++
+	}
+	var scs []statusCount
+	for status, n := range m {
+		scs = append(scs, statusCount{Status: status, Count: n})
+	}
+	sort.Slice(scs, func(i, j int) bool {
+		if scs[i].Count != scs[j].Count {
+			return scs[i].Count > scs[j].Count
+		}
+		return scs[i].Status < scs[j].Status
+	})
+	ss := make([]string, len(scs))
+	for i, sc := range scs {
+		ss[i] = fmt.Sprintf("%s: %d", unassignable(sc.Status, messages.LabelStatus), sc.Count)
+	}
+	fmt.Fprintln(w, strings.Join(ss, ", "))
+}
+
+// fields of an issue line
+const (
+	fieldID       = "id"
+	fieldSubject  = "subject"
+	fieldDueDate  = "duedate"
+	fieldAssignee = "assignee"
+	fieldPriority = "priority"
+	fieldStatus   = "status"
+	fieldTracker  = "tracker"
+)
+
+// defaultTrackerEmoji is the emoji for standard trackers.
+var defaultTrackerEmoji = map[string]string{
+	"Bug":     "🐛",
+	"Feature": "✨",
+	"Support": "🆘",
+}
+
+// neutralTrackerEmoji is the emoji for trackers not in the map.
+const neutralTrackerEmoji = "📄"
+
+// parseOverdueDays parses the days overdue of orange and red formatted as "Orange,Red".
+func parseOverdueDays(s string) ([2]int, error) {
+	var days [2]int
+	ds := strings.Split(s, ",")
+	if len(ds) != len(days) {
+		return days, fmt.Errorf("invalid overdue days: %s", s)
+	}
+	for i, d := range ds {
+		n, err := strconv.Atoi(strings.TrimSpace(d))
+		if err != nil {
+			return days, fmt.Errorf("invalid overdue days: %s", s)
+		}
+		days[i] = n
+	}
+	if days[0] > days[1] {
+		return days, fmt.Errorf("invalid overdue days: %s: orange must not be after red", s)
+	}
+	return days, nil
+}
+
+func parseLineFields(s string) ([]string, error) {
+	fields := strings.Split(s, ",")
+	for i, field := range fields {
+		field = strings.TrimSpace(field)
+		switch field {
+		case fieldID, fieldSubject, fieldDueDate, fieldAssignee, fieldPriority, fieldStatus, fieldTracker:
+		default:
+			return nil, fmt.Errorf("unknown line field: %s", field)
+		}
+		fields[i] = field
+	}
+	return fields, nil
+}
+
+func writeIssue(w io.Writer, opts options, rs renderState, is issue) {
+	fmt.Fprint(w, "-")
+	if emoji := severityEmoji(opts, rs, is); emoji != "" {
+		fmt.Fprint(w, " "+emoji)
+	}
+	for i, field := range rs.LineFields {
+		fmt.Fprint(w, fieldSeparator(rs.LineFields, i))
+		switch field {
+		case fieldID:
+			fmt.Fprint(w, issueLink(opts, rs, is.ID))
+		case fieldSubject:
+			fmt.Fprint(w, is.Subject)
+		case fieldDueDate:
+			fmt.Fprint(w, unassignable(formatTime(is.DueDate, rs.DateLayout), messages.LabelDueDate))
+		case fieldAssignee:
+			fmt.Fprintf(w, "(%s)", unassignable(getUser(opts, rs, is.AssignedTo), messages.LabelAssignee))
+		case fieldPriority:
+			fmt.Fprintf(w, "[%s]", unassignable(is.Priority, messages.LabelPriority))
+		case fieldStatus:
+			fmt.Fprintf(w, "[%s]", unassignable(is.Status, messages.LabelStatus))
+		case fieldTracker:
+			fmt.Fprint(w, trackerEmoji(rs.TrackerEmojis, is.Tracker))
+		}
+	}
+	if is.CustomField != "" {
+		fmt.Fprintf(w, " (%s)", is.CustomField)
+	}
+	if prev, ok := rs.AssigneeChanges[is.ID]; ok {
+		fmt.Fprintf(w, messages.AssigneeChange, unassignable(getUser(opts, rs, prev), messages.LabelAssignee), unassignable(getUser(opts, rs, is.AssignedTo), messages.LabelAssignee))
+	}
+	if opts.Slack.ShowTags {
+		for _, tag := range is.Tags {
+			fmt.Fprintf(w, " `#%s`", tag)
+		}
+	}
+	if opts.Slack.AssigneeLink && is.AssignedTo != nil {
+		fmt.Fprint(w, " "+formatLink(opts, rs, assigneeIssuesURL(opts, rs, is.AssignedTo.Id), messages.AllIssuesLink))
+	}
+	fmt.Fprint(w, "\n")
+}
+
+// severityEmoji returns the emoji put at the head of the issue by its due date.
+func severityEmoji(opts options, rs renderState, is issue) string {
+	switch {
+	case isExpiredAt(is, rs.Today):
+		return opts.Slack.ExpiredEmoji
+	case isNearAt(is, rs.Today, rs.Weekend):
+		return opts.Slack.NearEmoji
+	}
+	return ""
+}
+
+// parseTrackerEmoji parses "Tracker=emoji" pairs on top of the default map.
+func parseTrackerEmoji(pairs []string) (map[string]string, error) {
+	m := map[string]string{}
+	for tracker, emoji := range defaultTrackerEmoji {
+		m[tracker] = emoji
+	}
+	for _, pair := range pairs {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid tracker emoji: %s", pair)
+		}
+		m[kv[0]] = kv[1]
+	}
+	return m, nil
+}
+
+func trackerEmoji(emojis map[string]string, tracker string) string {
+	if emoji, ok := emojis[tracker]; ok {
+		return emoji
+	}
+	return neutralTrackerEmoji
+}
+
+// fieldSeparator returns the separator put before i-th field,
+// which keeps the default layout "- duedate id: subject(assignee)".
+func fieldSeparator(fields []string, i int) string {
+	if i == 0 {
+		return " "
+	}
+	switch {
+	case fields[i-1] == fieldID:
+		return ": "
+	case fields[i-1] == fieldSubject && fields[i] == fieldAssignee:
+		return ""
+	}
+	return " "
+}
+
+// removeField returns the fields without the target.
+func removeField(fields []string, target string) []string {
+	var kept []string
+	for _, field := range fields {
+		if field != target {
+			kept = append(kept, field)
+		}
+	}
+	return kept
+}
+
+func issueLink(opts options, rs renderState, id int) string {
+	if opts.Slack.PlainIssueIDs {
+		return fmt.Sprintf("#%d %s/issues/%d", id, opts.Redmine.Endpoint, id)
+	}
+	return formatLink(opts, rs, fmt.Sprintf("%s/issues/%d", opts.Redmine.Endpoint, id), fmt.Sprintf("#%d", id))
+}
+
+// formatLink returns the link in Slack's syntax, or in Markdown when rendering Markdown.
+func formatLink(opts options, rs renderState, url, text string) string {
+	if rs.Markdown {
+		return fmt.Sprintf("[%s](%s)", text, url)
+	}
+	return fmt.Sprintf("<%s|%s>", url, text)
+}
+
+// assigneeIssuesURL returns URL of the open issues assigned to the user in the target project.
+// It is across all projects unless only one project is targeted.
+func assigneeIssuesURL(opts options, rs renderState, assigneeID int) string {
+	if len(rs.Projects) != 1 {
+		return fmt.Sprintf("%s/issues?set_filter=1&status_id=o&assigned_to_id=%d", opts.Redmine.Endpoint, assigneeID)
+	}
+	return fmt.Sprintf("%s/projects/%d/issues?set_filter=1&status_id=o&assigned_to_id=%d", opts.Redmine.Endpoint, rs.Project.Id, assigneeID)
+}
+
+func unassignable(target, label string) string {
+	if target == "" {
+		return fmt.Sprintf(messages.Unset, label)
+	}
+	return target
+}
+
+func getUser(opts options, rs renderState, idname *redmine.IdName) string {
+	if idname == nil {
+		return ""
+	}
+	if rs.Markdown || rs.NoMention {
+		// mentions are meaningless outside Slack, or suppressed
+		return idname.Name
+	}
+	if group, ok := redmineGroups[idname.Id]; ok && opts.Redmine.IncludeGroupAssigned {
+		if id, ok := opts.Slack.GroupMapping[group]; ok {
+			return "<!subteam^" + id + ">"
+		}
+		if members := groupMembers[idname.Id]; len(members) > 0 {
+			mentions := make([]string, len(members))
+			for i := range members {
+				mentions[i] = getUser(opts, rs, &members[i])
+			}
+			return strings.Join(mentions, " ")
+		}
+		return messages.Group + group
+	}
+	redmineUser, err := redmineUsers.Get(idname.Id)
+	if err != nil {
+		if !isUnresolved(idname.Id) {
+			return idname.Name
+		}
+		if _, warned := unresolvedWarned.LoadOrStore(idname.Id, true); !warned {
+			msg := fmt.Sprintf("%d / %s not found", idname.Id, idname.Name)
+			warnf("%s", msg)
+			warnings.Add(msg)
+		}
+		suffix := opts.Slack.UnresolvedSuffix
+		if suffix == "" {
+			suffix = messages.UnresolvedAssignee
+		}
+		return idname.Name + suffix
+	}
+	for _, slackUser := range slackUsers {
+		if isSameUser(redmineUser, slackUser) {
+			return "<@" + slackUser.ID + ">"
+		}
+	}
+	unmatchedUsers.Set(redmineUser.Id, redmineUser)
+	return idname.Name
+}
+
+// unresolvedWarned holds IDs of the unresolved assignees already warned.
+var unresolvedWarned sync.Map
+
+// isUnresolved returns true if the assignee is neither a user nor a group of Redmine,
+// such as a deleted user. It is unknown unless all of them are loaded.
+func isUnresolved(id int) bool {
+	if !allUsersLoaded {
+		return false
+	}
+	_, isGroup := redmineGroups[id]
+	return !isGroup
+}
+
+// rules of user matching, used to tell why users are matched.
+const (
+	matchByLogin       = "login"
+	matchByEmail       = "email"
+	matchByName        = "name permutation"
+	matchByDisplayName = "display name permutation"
+	matchByUserMap     = "usermap"
+	matchByFuzzy       = "fuzzy name"
+)
+
+func isSameUser(redmineUser redmine.User, slackUser slackMember) bool {
+	return matchUser(redmineUser, slackUser) != ""
+}
+
+// matchUser returns the rule which matches given users,
+// or empty string when the users are not matched.
+func matchUser(redmineUser redmine.User, slackUser slackMember) string {
+	redmineUser.Lastname = normalizeName(redmineUser.Lastname)
+	redmineUser.Firstname = normalizeName(redmineUser.Firstname)
+	if redmineUser.Login == slackUser.Name {
+		return matchByLogin
+	}
+	if redmineUser.Mail != "" && strings.EqualFold(redmineUser.Mail, slackUser.Profile.Email) {
+		return matchByEmail
+	}
+	if isPermutedName(normalizeName(slackUser.RealName), redmineUser) {
+		return matchByName
+	}
+	if isPermutedName(normalizeName(slackUser.DisplayName), redmineUser) {
+		return matchByDisplayName
+	}
+	if mappedName, ok := userMap[slackUser.RealName]; ok {
+		slackUser.RealName = mappedName
+		if isSameUser(redmineUser, slackUser) {
+			return matchByUserMap
+		}
+	}
+	if fuzzy := fuzzyName(slackUser.RealName); fuzzy != "" {
+		switch fuzzy {
+		case fuzzyName(redmineUser.Lastname + redmineUser.Firstname), fuzzyName(redmineUser.Firstname + redmineUser.Lastname):
+			if _, logged := fuzzyMatched.LoadOrStore(redmineUser.Login+" "+slackUser.RealName, true); !logged {
+				warnf("%s and %s are matched by fuzzy name, add usermapping to match them explicitly", redmineUser.Login, slackUser.RealName)
+			}
+			return matchByFuzzy
+		}
+	}
+	return ""
+}
+
+// isPermutedName reports whether the name is the permutation of the name of redmine user.
+// Empty names never match, even if the name of redmine user is empty.
+func isPermutedName(name string, redmineUser redmine.User) bool {
+	if name == "" {
+		return false
+	}
+	switch name {
+	case
+		redmineUser.Lastname + redmineUser.Firstname,
+		redmineUser.Lastname + " " + redmineUser.Firstname,
+		redmineUser.Firstname + redmineUser.Lastname,
+		redmineUser.Firstname + " " + redmineUser.Lastname:
+
+		return true
+	}
+	return false
+}
+
+// fuzzyMatched records the pairs matched by fuzzy name, to log them only once.
+var fuzzyMatched sync.Map
+
+// fuzzyName normalizes the name by NFKC and strips all whitespaces,
+// to match names which differ only in width or spacing.
+func fuzzyName(name string) string {
+	return strings.Join(strings.Fields(norm.NFKC.String(normalizeName(name))), "")
+}
+
+func formatTime(t time.Time, layout string) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(layout)
+}
+
+// validateDateLayout checks the layout can format and parse a date back.
+func validateDateLayout(layout string) error {
+	known := time.Date(2006, time.January, 2, 0, 0, 0, 0, time.UTC)
+	parsed, err := time.Parse(layout, known.Format(layout))
+	if err != nil || !parsed.Equal(known) {
+		return fmt.Errorf("invalid date layout: %s", layout)
+	}
+	return nil
+}
+
+// fanout distributes issues into buckets per filter in the order of input.
+// When firstMatch is true, each issue goes only to the bucket of the first matching filter.
+func fanout(in []issue, firstMatch bool, filters ...func(issue) bool) [][]issue {
+	buckets := make([][]issue, len(filters))
+	for _, is := range in {
+		for _, i := range matchFilters(is, firstMatch, filters) {
+			buckets[i] = append(buckets[i], is)
+		}
+	}
+	return buckets
+}
+
+// matchFilters returns the indices of filters matching the issue.
+func matchFilters(is issue, firstMatch bool, filters []func(issue) bool) []int {
+	var matched []int
+	for i, filter := range filters {
+		if filter(is) {
+			matched = append(matched, i)
+			if firstMatch {
+				break
+			}
+		}
+	}
+	return matched
 }
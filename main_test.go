@@ -0,0 +1,424 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	flags "github.com/jessevdk/go-flags"
+	"github.com/lestrrat-go/slack/objects"
+	redmine "github.com/mattn/go-redmine"
+)
+
+var update = flag.Bool("update", false, "update golden files")
+
+// assertGolden compares got with the golden file in testdata, or rewrites it with -update.
+func assertGolden(t *testing.T, name, got string) {
+	t.Helper()
+	path := filepath.Join("testdata", name+".golden")
+	if *update {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(got), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != string(want) {
+		t.Errorf("%s differs from the golden file\ngot:\n%s\nwant:\n%s", name, got, want)
+	}
+}
+
+// fixedClock returns the clock of Wednesday, 2024-06-12.
+func fixedClock() time.Time {
+	return time.Date(2024, 6, 12, 9, 0, 0, 0, time.UTC)
+}
+
+func newFakeFetcher(t *testing.T) *fakeFetcher {
+	t.Helper()
+	b, err := ioutil.ReadFile(filepath.Join("testdata", "issues.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var issues []redmineIssue
+	if err := json.Unmarshal(b, &issues); err != nil {
+		t.Fatal(err)
+	}
+	return &fakeFetcher{
+		issues:   issues,
+		projects: []redmine.Project{{Id: 1, Name: "Web"}, {Id: 2, Name: "App"}},
+		users: []redmine.User{
+			{Id: 1, Login: "alice", Firstname: "Alice", Lastname: "Adams", Mail: "alice@example.com"},
+			{Id: 2, Login: "bob", Firstname: "Bob", Lastname: "Brown", Mail: "bob@example.com"},
+		},
+		statuses: []redmine.IssueStatus{
+			{Id: 1, Name: "New"},
+			{Id: 2, Name: "In Progress"},
+			{Id: 5, Name: "Closed", IsClosed: true},
+		},
+		priorities: []redmine.IdName{{Id: 1, Name: "Low"}, {Id: 2, Name: "Normal"}, {Id: 3, Name: "High"}},
+	}
+}
+
+func newFakePoster() *fakePoster {
+	alice := slackMember{User: objects.User{ID: "U001", Name: "alice", RealName: "Alice Adams"}}
+	alice.Profile.Email = "alice@example.com"
+	bob := slackMember{User: objects.User{ID: "U002", Name: "bob", RealName: "Bob Brown"}}
+	bob.Profile.Email = "bob@example.com"
+	return &fakePoster{
+		users:    []slackMember{alice, bob},
+		channels: []slackChannel{{ID: "C001", Name: "general"}},
+	}
+}
+
+func TestRunGolden(t *testing.T) {
+	defer func(c func() time.Time) { clock = c }(clock)
+	clock = fixedClock
+	// the message posted long ago is deleted on rotation
+	messageLog := filepath.Join(t.TempDir(), "posted_messages.json")
+	if err := ioutil.WriteFile(messageLog, []byte(`[{"channel":"C001","ts":"1000000000.000001"}]`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		args []string
+	}{
+		{"text", nil},
+		{"lang-en", []string{"--lang", "en"}},
+		{"block-kit", []string{"--block-kit"}},
+		{"rich-per-issue", []string{"--block-kit", "--rich-per-issue"}},
+		{"attachment", []string{"--slack-format", "attachment"}},
+		{"thread-by-assignee", []string{"--thread-by-assignee"}},
+		{"thread-near", []string{"--thread-near"}},
+		{"group-by-assignee", []string{"--group-by-assignee", "--sort-within-group", "priority"}},
+		{"post-at", []string{"--post-at", "2024-06-12T18:00:00Z"}},
+		{"channels", []string{"--slack-channel", "#general,#random", "--ops-channel", "#ops", "--max-message-age", "24h", "--message-log", messageLog}},
+		{"webhook", []string{"--slack-token", "", "--slack-webhook-url", "https://hooks.example.com/T0/B0/x"}},
+		{"workflow-webhook", []string{"--workflow-webhook", "https://hooks.example.com/workflows/x"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var opts options
+			args := append([]string{
+				"--redmine-endpoint", "https://redmine.example.com",
+				"--redmine-apikey", "key",
+				"--redmine-project", "Web",
+				"--redmine-finished-status", "Closed",
+				"--no-cache",
+				"--timezone", "UTC",
+				"--slack-token", "xoxb-test",
+				"--retry-attempts", "1",
+			}, tt.args...)
+			if _, err := flags.ParseArgs(&opts, args); err != nil {
+				t.Fatal(err)
+			}
+			poster := newFakePoster()
+			if err := run(context.Background(), opts, newFakeFetcher(t), poster); err != nil {
+				t.Fatal(err)
+			}
+			assertGolden(t, filepath.Join("run", tt.name), poster.calls.String())
+		})
+	}
+}
+
+func TestRunOfflineGolden(t *testing.T) {
+	defer func(c func() time.Time) { clock = c }(clock)
+	clock = fixedClock
+
+	tests := []struct {
+		name string
+		args []string
+	}{
+		// assignees are matched by their names in the issues
+		{"offline", nil},
+		// alice is matched by login in the users file, bob by name
+		{"offline-users-file", []string{"--users-file", filepath.Join("testdata", "users.json")}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// users are not loaded from Redmine
+			redmineUsers = redmineUserMap{}
+			var opts options
+			args := append([]string{
+				"--redmine-endpoint", "https://redmine.example.com",
+				"--redmine-project", "Web",
+				"--redmine-finished-status", "5",
+				"--issues-file", filepath.Join("testdata", "issues.json"),
+				"--timezone", "UTC",
+				"--slack-token", "xoxb-test",
+				"--retry-attempts", "1",
+			}, tt.args...)
+			if _, err := flags.ParseArgs(&opts, args); err != nil {
+				t.Fatal(err)
+			}
+			poster := newFakePoster()
+			if err := run(context.Background(), opts, &fakeFetcher{}, poster); err != nil {
+				t.Fatal(err)
+			}
+			assertGolden(t, filepath.Join("run", tt.name), poster.calls.String())
+		})
+	}
+}
+
+func TestSuggestUserMapDryRun(t *testing.T) {
+	defer func(c func() time.Time) { clock = c }(clock)
+	clock = fixedClock
+
+	for _, dryRun := range []bool{true, false} {
+		path := filepath.Join(t.TempDir(), "suggestions.json")
+		args := []string{
+			"--redmine-endpoint", "https://redmine.example.com",
+			"--redmine-apikey", "key",
+			"--redmine-project", "Web",
+			"--redmine-finished-status", "Closed",
+			"--no-cache",
+			"--timezone", "UTC",
+			"--slack-token", "xoxb-test",
+			"--retry-attempts", "1",
+			"--suggest-usermap", path,
+		}
+		if dryRun {
+			args = append(args, "--dry-run")
+		}
+		var opts options
+		if _, err := flags.ParseArgs(&opts, args); err != nil {
+			t.Fatal(err)
+		}
+		if err := run(context.Background(), opts, newFakeFetcher(t), newFakePoster()); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := os.Stat(path); dryRun != os.IsNotExist(err) {
+			t.Errorf("dry run %t: stat of the suggestions file: %v", dryRun, err)
+		}
+	}
+}
+
+func TestNotifyError(t *testing.T) {
+	defer func(c func() time.Time) { clock = c }(clock)
+	clock = fixedClock
+	refused := &url.Error{Op: "Get", URL: "http://127.0.0.1:1/issues.json?key=SECRETKEY", Err: errors.New("dial tcp 127.0.0.1:1: connect: connection refused")}
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{refused, "unreachable"},
+		{fmt.Errorf("Web: %s", refused), "Web: Get: dial tcp 127.0.0.1:1: connect: connection refused"},
+		{&httpStatusError{Service: "redmine", StatusCode: http.StatusForbidden, Status: "403 Forbidden"}, "redmine: 403 Forbidden"},
+		{errPartialFailure, errPartialFailure.Error()},
+	}
+	for _, tt := range tests {
+		poster := newFakePoster()
+		opts := options{Slack: slackOptions{ErrorChannel: "ops"}}
+		if err := notifyError(opts, poster, tt.err); err != nil {
+			t.Fatal(err)
+		}
+		got := poster.calls.String()
+		if strings.Contains(got, "SECRETKEY") {
+			t.Errorf("the API key is posted: %s", got)
+		}
+		if !strings.Contains(got, "failed at 2024-06-12T09:00:00Z") {
+			t.Errorf("posted %q, want the time of the clock", got)
+		}
+		if !strings.HasSuffix(got, ": "+tt.want) {
+			t.Errorf("posted %q, want the reason %q", got, tt.want)
+		}
+	}
+}
+
+func TestNearDeadline(t *testing.T) {
+	tests := []struct {
+		day  string
+		days int
+		want string
+	}{
+		{"2024-06-10", 0, "2024-06-14"}, // Monday to Friday
+		{"2024-06-12", 0, "2024-06-14"},
+		{"2024-06-14", 0, "2024-06-14"}, // Friday is the deadline itself
+		{"2024-06-15", 0, "2024-06-14"}, // Friday of the week has passed on Saturday
+		{"2024-06-16", 0, "2024-06-21"}, // Sunday starts the week
+		{"2024-06-12", 3, "2024-06-15"},
+		{"2024-06-12", -1, "2024-06-14"},
+	}
+	for _, tt := range tests {
+		if got := nearDeadline(date(tt.day), tt.days); !got.Equal(date(tt.want)) {
+			t.Errorf("nearDeadline(%s, %d) = %s, want %s", tt.day, tt.days, got.Format("2006-01-02"), tt.want)
+		}
+	}
+}
+
+func TestDatePredicates(t *testing.T) {
+	day := date("2024-06-12")
+	deadline := nearDeadline(day, 0)
+	ignoreNotStarted = true
+	defer func() { ignoreNotStarted = false }()
+	tests := []struct {
+		name    string
+		is      issue
+		started bool
+		expired bool
+		near    bool
+	}{
+		{"no due date", issue{}, true, false, false},
+		{"due long ago", issue{DueDate: date("2024-01-01")}, true, true, false},
+		{"due yesterday", issue{DueDate: date("2024-06-11")}, true, true, false},
+		{"due today", issue{DueDate: date("2024-06-12")}, true, false, true},
+		{"due tomorrow", issue{DueDate: date("2024-06-13")}, true, false, true},
+		{"due on the deadline", issue{DueDate: date("2024-06-14")}, true, false, false},
+		{"due after the deadline", issue{DueDate: date("2024-07-01")}, true, false, false},
+		{"started today", issue{DueDate: date("2024-06-11"), StartDate: date("2024-06-12")}, true, true, false},
+		{"not started, due yesterday", issue{DueDate: date("2024-06-11"), StartDate: date("2024-06-13")}, false, false, false},
+		{"not started, due tomorrow", issue{DueDate: date("2024-06-13"), StartDate: date("2024-06-13")}, false, false, false},
+	}
+	for _, tt := range tests {
+		if got := isStartedAt(tt.is, day); got != tt.started {
+			t.Errorf("%s: isStartedAt = %t, want %t", tt.name, got, tt.started)
+		}
+		if got := isExpiredAt(tt.is, day); got != tt.expired {
+			t.Errorf("%s: isExpiredAt = %t, want %t", tt.name, got, tt.expired)
+		}
+		if got := isNearAt(tt.is, day, deadline); got != tt.near {
+			t.Errorf("%s: isNearAt = %t, want %t", tt.name, got, tt.near)
+		}
+	}
+}
+
+func TestDaysOverdue(t *testing.T) {
+	day := date("2024-06-12")
+	tests := []struct {
+		due       string
+		overdue   int
+		remaining int
+	}{
+		{"2024-06-03", 9, -9},
+		{"2024-06-11", 1, -1},
+		{"2024-06-12", 0, 0},
+		{"2024-06-13", -1, 1},
+		{"2024-07-01", -19, 19},
+	}
+	for _, tt := range tests {
+		is := issue{DueDate: date(tt.due)}
+		if got := daysOverdue(is, day); got != tt.overdue {
+			t.Errorf("daysOverdue(%s) = %d, want %d", tt.due, got, tt.overdue)
+		}
+		if got := daysRemaining(is, day); got != tt.remaining {
+			t.Errorf("daysRemaining(%s) = %d, want %d", tt.due, got, tt.remaining)
+		}
+	}
+}
+
+func TestAverageOverdue(t *testing.T) {
+	day := date("2024-06-12")
+	tests := []struct {
+		name string
+		iss  []issue
+		avg  float64
+		ok   bool
+	}{
+		{"no issues", nil, 0, false},
+		{"no due date", []issue{{ID: 1}}, 0, false},
+		{"one", []issue{{DueDate: date("2024-06-10")}}, 2, true},
+		{"undated are ignored", []issue{{DueDate: date("2024-06-10")}, {DueDate: date("2024-06-07")}, {}}, 3.5, true},
+	}
+	for _, tt := range tests {
+		avg, ok := averageOverdue(tt.iss, day)
+		if avg != tt.avg || ok != tt.ok {
+			t.Errorf("%s: averageOverdue = (%v, %t), want (%v, %t)", tt.name, avg, ok, tt.avg, tt.ok)
+		}
+	}
+}
+
+func TestSetClock(t *testing.T) {
+	defer func(n, d, w time.Time) { now, today, weekend = n, d, w }(now, today, weekend)
+	tests := []struct {
+		t        time.Time
+		nearDays int
+		today    string
+		weekend  string
+	}{
+		{time.Date(2024, 6, 12, 23, 59, 0, 0, time.UTC), 0, "2024-06-12", "2024-06-14"},
+		{time.Date(2024, 6, 12, 0, 0, 0, 0, time.UTC), 7, "2024-06-12", "2024-06-19"},
+	}
+	for _, tt := range tests {
+		setClock(tt.t, tt.nearDays)
+		if !now.Equal(tt.t) || !today.Equal(date(tt.today)) || !weekend.Equal(date(tt.weekend)) {
+			t.Errorf("setClock(%s, %d): now, today, weekend = %s, %s, %s", tt.t, tt.nearDays, now, today, weekend)
+		}
+	}
+}
+
+func TestDiffReopened(t *testing.T) {
+	finished := []int{5}
+	past := []snapshot{
+		{Date: "2020-03-30", Issues: []snapshotIssue{{ID: 1, StatusID: 5}, {ID: 2, StatusID: 5}, {ID: 3, StatusID: 1}}},
+		// the closed issue #1 is not recorded any more, and #2 was reopened
+		{Date: "2020-03-31", Issues: []snapshotIssue{{ID: 2, StatusID: 1}, {ID: 3, StatusID: 5}}},
+	}
+	iss := []issue{{ID: 1, StatusID: 1}, {ID: 2, StatusID: 1}, {ID: 3, StatusID: 1}, {ID: 4, StatusID: 1}}
+	var ids []int
+	for _, is := range diffReopened(past, iss, finished) {
+		ids = append(ids, is.ID)
+	}
+	if fmt.Sprint(ids) != "[1 3]" {
+		t.Errorf("reopened = %v, want [1 3]", ids)
+	}
+}
+
+func TestFanout(t *testing.T) {
+	in := make([]issue, 3000)
+	for i := range in {
+		in[i] = issue{ID: i}
+	}
+	even := func(is issue) bool { return is.ID%2 == 0 }
+	all := func(is issue) bool { return true }
+	tests := []struct {
+		name       string
+		firstMatch bool
+		want       []int
+	}{
+		{"all", false, []int{1500, 3000}},
+		{"first match", true, []int{1500, 1500}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := fanout(in, tt.firstMatch, even, all)
+			for i, iss := range out {
+				if len(iss) != tt.want[i] {
+					t.Errorf("bucket %d: %d issues, want %d", i, len(iss), tt.want[i])
+				}
+				for k := 1; k < len(iss); k++ {
+					if iss[k-1].ID >= iss[k].ID {
+						t.Fatalf("bucket %d: issues are not in the order of input at %d", i, k)
+					}
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkFanout(b *testing.B) {
+	setClock(fixedClock(), 0)
+	in := make([]issue, 10000)
+	for i := range in {
+		in[i] = issue{ID: i, DueDate: today.AddDate(0, 0, i%30-15), CreatedOn: today.AddDate(0, 0, -i%90)}
+	}
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		fanout(in, false, isUndated, isExpired, isNear, isSLABreached)
+	}
+}
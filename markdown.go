@@ -0,0 +1,34 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"strings"
+)
+
+// writeMarkdown renders the report in Markdown and writes it to the output file.
+func writeMarkdown(opts options, iss, expired, near, sla, undated []issue) error {
+	rs, err := newRenderState(opts)
+	if err != nil {
+		return err
+	}
+	rs.Markdown = true
+	rep := renderReport(opts, rs, iss, expired, near, sla, undated)
+	infof("write the report to %s", opts.OutputFile)
+	return ioutil.WriteFile(opts.OutputFile, []byte(rep.Text), 0644)
+}
+
+// joinErrors returns an error of all errors, or nil when there is no error.
+func joinErrors(errs []error) error {
+	switch len(errs) {
+	case 0:
+		return nil
+	case 1:
+		return errs[0]
+	}
+	msgs := make([]string, len(errs))
+	for i, err := range errs {
+		msgs[i] = err.Error()
+	}
+	return errors.New(strings.Join(msgs, "; "))
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// postedMessage is a report posted to Slack, tracked to be deleted later.
+type postedMessage struct {
+	Channel string `json:"channel"`
+	TS      string `json:"ts"`
+}
+
+// PostedAt returns the time the message is posted at, parsed from its ts.
+func (pm postedMessage) PostedAt() (time.Time, error) {
+	sec, err := strconv.ParseInt(strings.SplitN(pm.TS, ".", 2)[0], 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(sec, 0), nil
+}
+
+func loadPostedMessages(path string) ([]postedMessage, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+	var pms []postedMessage
+	if err := json.NewDecoder(f).Decode(&pms); err != nil {
+		return nil, err
+	}
+	return pms, nil
+}
+
+func savePostedMessages(path string, pms []postedMessage) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return json.NewEncoder(f).Encode(pms)
+}
+
+// rotateMessages deletes tracked messages older than --max-message-age,
+// then tracks the newly posted messages.
+// Deleting the messages requires chat:write scope.
+func rotateMessages(ctx context.Context, opts options, poster messagePoster, posted []postedMessage) error {
+	pms, err := loadPostedMessages(opts.Slack.MessageLog)
+	if err != nil {
+		return err
+	}
+	deadline := clock().Add(-opts.Slack.MaxMessageAge)
+	var kept []postedMessage
+	for _, pm := range pms {
+		postedAt, err := pm.PostedAt()
+		if err != nil {
+			warnf("invalid ts of posted message: %s", pm.TS)
+			continue
+		}
+		if postedAt.After(deadline) {
+			kept = append(kept, pm)
+			continue
+		}
+		infof("delete message %s", pm.TS)
+		if err := poster.DeleteMessage(ctx, pm); err != nil {
+			warnf("failed to delete message %s: %s", pm.TS, err)
+			kept = append(kept, pm)
+		}
+	}
+	return savePostedMessages(opts.Slack.MessageLog, append(kept, posted...))
+}
+
+// DeleteMessage deletes the message using chat.delete.
+// Messages already deleted are considered as deleted successfully.
+func (p *slackPoster) DeleteMessage(ctx context.Context, pm postedMessage) error {
+	params := url.Values{}
+	params.Set("channel", pm.Channel)
+	params.Set("ts", pm.TS)
+	err := p.call(ctx, "chat.delete", params, nil)
+	if e, ok := err.(*slackAPIError); ok && e.Code == "message_not_found" {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,167 @@
+package main
+
+// languages of messages
+const (
+	langJapanese = "ja"
+	langEnglish  = "en"
+)
+
+// messageSet is the user-facing strings of a language.
+// Formats of headers take the project name, the scope label and the count,
+// which are referred by index when the order differs in the language.
+type messageSet struct {
+	ScopeWatched string
+	ScopeMine    string
+	AllProjects  string
+
+	ExpiredHead         string
+	AverageOverdue      string
+	SampleNote          string
+	LimitNote           string
+	NearHead            string
+	SLAHead             string
+	ReopenedHead        string
+	UndatedHead         string
+	StatusBreakdownHead string
+	NoOpenIssues        string
+	// OverdueRate takes the project name, the scope label, the rate, the count of expired and open issues.
+	OverdueRate string
+	// Totals takes the count of open, expired and near issues.
+	Totals string
+	// WeekOverWeek takes the change of the count of expired and near issues since last week.
+	WeekOverWeek string
+	// FetchFailed takes the project and the reason of the error.
+	FetchFailed string
+
+	ThreadHead      string
+	ThreadHeadCount string
+	GroupHead       string
+	GroupCount      string
+	Others          string
+	RollupLine      string
+	AssigneeChange  string
+	AllIssuesLink   string
+	Group           string
+
+	// Unset takes the label of the field.
+	Unset         string
+	LabelDueDate  string
+	LabelAssignee string
+	LabelPriority string
+	LabelStatus   string
+	// UnresolvedAssignee is put after the name of assignees not found on Redmine.
+	UnresolvedAssignee string
+	// DaysOverdue and DaysRemaining take the number of days.
+	DaysOverdue   string
+	DaysRemaining string
+
+	DigestHead          string
+	DigestExpired       string
+	DigestResolved      string
+	DigestExpiredChange string
+}
+
+var messageSets = map[string]messageSet{
+	langJapanese: {
+		ScopeWatched: "ウォッチ中の",
+		ScopeMine:    "自分の",
+		AllProjects:  "全プロジェクト",
+
+		ExpiredHead:         "%s の%s期限切れのチケットは *%d件* です",
+		AverageOverdue:      " (平均超過 %.1f日)",
+		SampleNote:          "(%d件中 %d件を表示)\n",
+		LimitNote:           "...他 %d件\n",
+		NearHead:            "%s の%s期限切れが近いチケットは *%d件* です\n",
+		SLAHead:             "%s の%sSLA超過のチケットは *%d件* です\n",
+		ReopenedHead:        "%s の%s再オープンしたチケットは *%d件* です\n",
+		UndatedHead:         "%s の%s期日が未設定のチケットは *%d件* です。*期日を設定してください*\n",
+		StatusBreakdownHead: "%s の%s未完了チケットのステータス内訳\n",
+		NoOpenIssues:        "%s の%s未完了のチケットはありません\n",
+		OverdueRate:         "%s の%s未完了チケットの *%.0f%%* (%d / %d) が期限切れです\n",
+		Totals:              "対象チケット合計: %d件 (期限切れ %d / 期限間近 %d)\n",
+		WeekOverWeek:        "先週比: 期限切れ %+d / 期限間近 %+d\n",
+		FetchFailed:         "*取得失敗*: %s (%s)\n",
+
+		ThreadHead:      "%s の期限切れのチケット\n",
+		ThreadHeadCount: "%s の期限切れのチケット (%d件)\n",
+		GroupHead:       "*%s*\n",
+		GroupCount:      "*%s* (%d件)\n",
+		Others:          "その他",
+		RollupLine:      "- %s: 期限切れ%d / 間近%d\n",
+		AssigneeChange:  " 担当変更: %s→%s",
+		AllIssuesLink:   "(全チケット)",
+		Group:           "グループ: ",
+
+		Unset:         "%s未設定",
+		LabelDueDate:  "期日",
+		LabelAssignee: "担当",
+		LabelPriority: "優先度",
+		LabelStatus:   "ステータス",
+
+		UnresolvedAssignee: "(退職?)",
+		DaysOverdue:        "%d日超過",
+		DaysRemaining:      "あと%d日",
+
+		DigestHead:          "%s の週次ダイジェスト (%s 〜 %s)\n",
+		DigestExpired:       "期限切れになったチケットは *%d件* です\n",
+		DigestResolved:      "解決したチケットは *%d件* です\n",
+		DigestExpiredChange: "期限切れのチケットは %d件 から %d件 (%+d) になりました\n",
+	},
+	langEnglish: {
+		ScopeWatched: "watched ",
+		ScopeMine:    "my ",
+		AllProjects:  "All projects",
+
+		ExpiredHead:         "%[1]s: *%[3]d* %[2]sissues are overdue",
+		AverageOverdue:      " (%.1f days overdue on average)",
+		SampleNote:          "(showing %[2]d of %[1]d)\n",
+		LimitNote:           "...and %d more\n",
+		NearHead:            "%[1]s: *%[3]d* %[2]sissues are due soon\n",
+		SLAHead:             "%[1]s: *%[3]d* %[2]sissues are over SLA\n",
+		ReopenedHead:        "%[1]s: *%[3]d* %[2]sissues are reopened\n",
+		UndatedHead:         "%[1]s: *%[3]d* %[2]sissues have no due date. *Please set the due date*\n",
+		StatusBreakdownHead: "%[1]s: %[2]sopen issues by status\n",
+		NoOpenIssues:        "%[1]s: no %[2]sopen issues\n",
+		OverdueRate:         "%[1]s: *%.0[3]f%%* (%[4]d / %[5]d) of %[2]sopen issues are overdue\n",
+		Totals:              "Total: %d issues (overdue %d / due soon %d)\n",
+		WeekOverWeek:        "Since last week: overdue %+d / due soon %+d\n",
+		FetchFailed:         "*Failed to fetch*: %s (%s)\n",
+
+		ThreadHead:      "Overdue issues of %s\n",
+		ThreadHeadCount: "Overdue issues of %s (%d)\n",
+		GroupHead:       "*%s*\n",
+		GroupCount:      "*%s* (%d)\n",
+		Others:          "Others",
+		RollupLine:      "- %s: overdue %d / due soon %d\n",
+		AssigneeChange:  " assignee changed: %s→%s",
+		AllIssuesLink:   "(all issues)",
+		Group:           "group: ",
+
+		Unset:         "no %s",
+		LabelDueDate:  "due date",
+		LabelAssignee: "assignee",
+		LabelPriority: "priority",
+		LabelStatus:   "status",
+
+		UnresolvedAssignee: " (left?)",
+		DaysOverdue:        "%d days overdue",
+		DaysRemaining:      "%d days left",
+
+		DigestHead:          "Weekly digest of %s (%s - %s)\n",
+		DigestExpired:       "*%d* issues went overdue\n",
+		DigestResolved:      "*%d* issues are resolved\n",
+		DigestExpiredChange: "Overdue issues changed from %d to %d (%+d)\n",
+	},
+}
+
+// messages is the message set of the language given by the options.
+var messages = messageSets[langJapanese]
+
+// selectMessages returns the message set of the language,
+// falling back to English for unknown languages.
+func selectMessages(lang string) messageSet {
+	if m, ok := messageSets[lang]; ok {
+		return m
+	}
+	return messageSets[langEnglish]
+}
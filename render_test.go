@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	redmine "github.com/mattn/go-redmine"
+)
+
+func date(s string) time.Time {
+	t, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+// renderFixture returns the issues to be rendered at Wednesday, 2024-06-12.
+func renderFixture() (iss, expired, near, sla, undated []issue) {
+	alice := &redmine.IdName{Id: 1, Name: "Alice Adams"}
+	bob := &redmine.IdName{Id: 2, Name: "Bob Brown"}
+	expired = []issue{
+		{ID: 101, Subject: "Fix login failure", DueDate: date("2024-06-03"), Priority: "Normal", PriorityID: 2, Status: "New", Tracker: "Bug", ProjectID: 1, AssignedTo: alice},
+		{ID: 102, Subject: "Update the privacy policy", DueDate: date("2024-06-07"), Priority: "High", PriorityID: 3, Status: "In Progress", Tracker: "Task", ProjectID: 2, AssignedTo: bob},
+		{ID: 109, Subject: "Reply to the customer", DueDate: date("2024-06-11"), Priority: "High", PriorityID: 3, Status: "New", Tracker: "Support", ProjectID: 1, AssignedTo: alice},
+	}
+	near = []issue{
+		{ID: 103, Subject: "Review the release notes", DueDate: date("2024-06-12"), Priority: "Normal", PriorityID: 2, Status: "New", Tracker: "Task", ProjectID: 1, AssignedTo: alice},
+		{ID: 104, Subject: "Renew the certificate", DueDate: date("2024-06-13"), Priority: "High", PriorityID: 3, Status: "New", Tracker: "Task", ProjectID: 2},
+	}
+	sla = []issue{
+		{ID: 110, Subject: "Answer the inquiry", Priority: "High", PriorityID: 3, Status: "New", Tracker: "Support", ProjectID: 1, AssignedTo: bob, CreatedOn: date("2024-06-01")},
+	}
+	undated = []issue{
+		{ID: 107, Subject: "Write the onboarding guide", Priority: "Normal", PriorityID: 2, Status: "New", Tracker: "Task", ProjectID: 1, AssignedTo: alice},
+	}
+	iss = append(append(append(append([]issue{}, expired...), near...), sla...), undated...)
+	iss = append(iss, issue{ID: 105, Subject: "Plan the next sprint", DueDate: date("2024-07-01"), Priority: "Low", PriorityID: 1, Status: "New", Tracker: "Task", ProjectID: 1, AssignedTo: bob})
+	return iss, expired, near, sla, undated
+}
+
+func newTestRenderState() renderState {
+	today := date("2024-06-12")
+	return renderState{
+		// mentions depend on the users loaded in the run, which are out of rendering
+		NoMention:  true,
+		Today:      today,
+		Weekend:    nearDeadline(today, 0),
+		Project:    redmine.Project{Id: 1, Name: "Web"},
+		Projects:   []redmine.Project{{Id: 1, Name: "Web"}},
+		LineFields: []string{fieldDueDate, fieldID, fieldSubject, fieldAssignee, fieldStatus},
+		DateLayout: "2006-01-02",
+	}
+}
+
+func TestRenderReportGolden(t *testing.T) {
+	defer func(m messageSet) { messages = m }(messages)
+	messages = messageSets[langJapanese]
+
+	tests := []struct {
+		name   string
+		modify func(*options, *renderState)
+	}{
+		{"default", func(opts *options, rs *renderState) {}},
+		{"markdown", func(opts *options, rs *renderState) {
+			rs.Markdown = true
+		}},
+		{"limit", func(opts *options, rs *renderState) {
+			opts.Slack.Limit = 1
+		}},
+		{"sample", func(opts *options, rs *renderState) {
+			opts.Slack.Sample = 2
+		}},
+		{"group-by-assignee", func(opts *options, rs *renderState) {
+			opts.Slack.GroupByAssignee = true
+			opts.Slack.SortWithinGroup = fieldPriority
+		}},
+		{"group-by-assignee-limit", func(opts *options, rs *renderState) {
+			opts.Slack.GroupByAssignee = true
+			opts.Slack.Limit = 1
+			opts.Slack.GroupSeparator = groupSeparatorDivider
+		}},
+		{"near-splits", func(opts *options, rs *renderState) {
+			rs.NearSplits = []nearSplit{{Label: "今日", Days: 0}}
+		}},
+		{"sla-reopened-undated", func(opts *options, rs *renderState) {
+			opts.Slack.ShowReopened = true
+			rs.SLAWindows = map[string]int{"High": 3}
+			rs.Reopened = []issue{{ID: 106, Subject: "Migrate the database", DueDate: date("2024-06-20"), Status: "New", AssignedTo: &redmine.IdName{Id: 2, Name: "Bob Brown"}}}
+			rs.RequireDueDate = true
+		}},
+		{"summaries", func(opts *options, rs *renderState) {
+			opts.Redmine.ShowStatusBreakdown = true
+			opts.Slack.ShowOverdueRate = true
+			opts.Slack.ShowAverageOverdue = true
+			opts.Slack.ShowTotals = true
+			rs.LastWeek = &historyEntry{Expired: 5, Near: 1}
+			rs.Footer = "footer of 2024-06-12\n"
+		}},
+		{"rollup", func(opts *options, rs *renderState) {
+			opts.Slack.RollupByAssignee = true
+		}},
+		{"projects", func(opts *options, rs *renderState) {
+			rs.Projects = []redmine.Project{{Id: 1, Name: "Web"}, {Id: 2, Name: "App"}}
+			rs.Project = combineProjects(rs.Projects)
+			rs.Failures = []string{fmt.Sprintf(messages.FetchFailed, "Ops", "500 Internal Server Error")}
+		}},
+		{"line-fields", func(opts *options, rs *renderState) {
+			opts.Slack.ExpiredEmoji = ":red_circle:"
+			opts.Slack.NearEmoji = ":large_yellow_circle:"
+			opts.Slack.AssigneeLink = true
+			rs.LineFields = []string{fieldTracker, fieldID, fieldSubject, fieldPriority}
+			rs.TrackerEmojis = map[string]string{"Bug": ":bug:"}
+			rs.AssigneeChanges = map[int]*redmine.IdName{101: {Id: 2, Name: "Bob Brown"}}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := options{}
+			opts.Redmine.Endpoint = "https://redmine.example.com"
+			opts.Slack.SortWithinGroup = fieldDueDate
+			rs := newTestRenderState()
+			tt.modify(&opts, &rs)
+			iss, expired, near, sla, undated := renderFixture()
+			rep := renderReport(opts, rs, iss, expired, near, sla, undated)
+			got := fmt.Sprintf("breaks: %v\nsection heads: %q\n----\n%s", rep.Breaks, rep.SectionHeads, rep.Text)
+			assertGolden(t, filepath.Join("render", tt.name), got)
+		})
+	}
+}
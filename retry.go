@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// maxBackoff is the cap of the delay between retries.
+const maxBackoff = 30 * time.Second
+
+// retry policy of remote calls, set from the options
+var (
+	retryAttempts = 1
+	retryBackoff  time.Duration
+)
+
+// httpStatusError is an error response of HTTP API.
+type httpStatusError struct {
+	Service    string
+	StatusCode int
+	Status     string
+	// RetryAfter is given by Retry-After header of 429 Too Many Requests.
+	RetryAfter time.Duration
+}
+
+func (e *httpStatusError) Error() string {
+	return e.Service + ": " + e.Status
+}
+
+func newHTTPStatusError(service string, resp *http.Response) *httpStatusError {
+	e := &httpStatusError{Service: service, StatusCode: resp.StatusCode, Status: resp.Status}
+	if sec, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+		e.RetryAfter = time.Duration(sec) * time.Second
+	}
+	return e
+}
+
+// transient error codes of Slack Web API
+var transientSlackErrors = []string{"ratelimited", "internal_error", "fatal_error", "service_unavailable", "request_timeout"}
+
+// isRetryable reports whether the call may succeed when it is retried.
+// It is only for idempotent calls, as a timeout does not tell whether the request was processed.
+func isRetryable(err error) bool {
+	if isUnsent(err) {
+		return true
+	}
+	var se *httpStatusError
+	if errors.As(err, &se) {
+		return se.StatusCode >= http.StatusInternalServerError
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var ne net.Error
+	if errors.As(err, &ne) && ne.Timeout() {
+		return true
+	}
+	// lestrrat-go/slack returns the error code of Slack as a message
+	for _, code := range transientSlackErrors {
+		if strings.Contains(err.Error(), code) {
+			return true
+		}
+	}
+	return false
+}
+
+// isUnsent reports whether the request was rejected without being processed,
+// so that even a non-idempotent call can be retried.
+func isUnsent(err error) bool {
+	var se *httpStatusError
+	if errors.As(err, &se) {
+		return se.StatusCode == http.StatusTooManyRequests
+	}
+	return errors.Is(err, syscall.ECONNREFUSED) || strings.Contains(err.Error(), "ratelimited")
+}
+
+// withRetry calls idempotent fn until it succeeds up to the attempts,
+// waiting the exponential backoff between calls.
+// Retry-After is honored when the error tells it.
+func withRetry(ctx context.Context, fn func() error, attempts int, backoff time.Duration) error {
+	return retry(ctx, fn, isRetryable, attempts, backoff)
+}
+
+// withRetryUnsent is withRetry for non-idempotent fn such as posting a message,
+// which is retried only when the request was not processed.
+func withRetryUnsent(ctx context.Context, fn func() error, attempts int, backoff time.Duration) error {
+	return retry(ctx, fn, isUnsent, attempts, backoff)
+}
+
+func retry(ctx context.Context, fn func() error, retryable func(error) bool, attempts int, backoff time.Duration) error {
+	delay := backoff
+	for i := 1; ; i++ {
+		err := fn()
+		if err == nil || i >= attempts || !retryable(err) {
+			return err
+		}
+		wait := delay
+		var se *httpStatusError
+		if errors.As(err, &se) && se.RetryAfter > 0 {
+			wait = se.RetryAfter
+		}
+		warnf("retry in %s (%d/%d): %s", wait, i, attempts-1, err)
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return err
+		}
+		if delay *= 2; delay > maxBackoff {
+			delay = maxBackoff
+		}
+	}
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	redmine "github.com/mattn/go-redmine"
+)
+
+// snapshotDateLayout is the layout of the date of snapshots.
+const snapshotDateLayout = "2006-01-02"
+
+// snapshot is a record of issues at a run, used to tell the movement of issues across runs.
+// Issues in finished status are recorded too, while closed ones only when they were updated since the last snapshot.
+type snapshot struct {
+	Date   string          `json:"date"`
+	Issues []snapshotIssue `json:"issues"`
+}
+
+type snapshotIssue struct {
+	ID         int             `json:"id"`
+	Subject    string          `json:"subject"`
+	Expired    bool            `json:"expired"`
+	StatusID   int             `json:"status_id"`
+	AssignedTo *redmine.IdName `json:"assigned_to,omitempty"`
+}
+
+func takeSnapshot(iss []issue) snapshot {
+	snap := snapshot{Date: today.Format(snapshotDateLayout)}
+	for _, is := range iss {
+		snap.Issues = append(snap.Issues, snapshotIssue{
+			ID:         is.ID,
+			Subject:    is.Subject,
+			Expired:    isExpired(is),
+			StatusID:   is.StatusID,
+			AssignedTo: is.AssignedTo,
+		})
+	}
+	return snap
+}
+
+// loadSnapshots loads snapshots from the file.
+// A missing file is considered as no snapshots.
+func loadSnapshots(path string) ([]snapshot, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+	var snaps []snapshot
+	if err := json.NewDecoder(f).Decode(&snaps); err != nil {
+		return nil, err
+	}
+	return snaps, nil
+}
+
+func saveSnapshots(path string, snaps []snapshot) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return json.NewEncoder(f).Encode(snaps)
+}
+
+// recordSnapshot adds the snapshot of given issues to the file,
+// replacing the snapshot taken on the same day.
+// The last snapshot taken before today is returned, or nil on the first run.
+func recordSnapshot(path string, iss []issue) (*snapshot, error) {
+	infof("record snapshot")
+	snaps, err := loadSnapshots(path)
+	if err != nil {
+		return nil, err
+	}
+	snap := takeSnapshot(iss)
+	if n := len(snaps); n > 0 && snaps[n-1].Date == snap.Date {
+		snaps = snaps[:n-1]
+	}
+	var prev *snapshot
+	if n := len(snaps); n > 0 {
+		prev = &snaps[n-1]
+	}
+	if err := saveSnapshots(path, append(snaps, snap)); err != nil {
+		return nil, err
+	}
+	return prev, nil
+}
+
+// lastSnapshot returns the last snapshot taken before today, or nil on the first run.
+// Unlike recordSnapshot, the file is left untouched, which is for dry run.
+func lastSnapshot(path string) (*snapshot, error) {
+	snaps, err := pastSnapshots(path)
+	if err != nil {
+		return nil, err
+	}
+	if n := len(snaps); n > 0 {
+		return &snaps[n-1], nil
+	}
+	return nil, nil
+}
+
+// pastSnapshots returns the snapshots taken before today.
+func pastSnapshots(path string) ([]snapshot, error) {
+	snaps, err := loadSnapshots(path)
+	if err != nil {
+		return nil, err
+	}
+	date := today.Format(snapshotDateLayout)
+	for n := len(snaps); n > 0 && snaps[n-1].Date == date; n-- {
+		snaps = snaps[:n-1]
+	}
+	return snaps, nil
+}
+
+// diffAssignees returns the previous assignees of issues whose assignee has changed since the snapshot.
+func diffAssignees(prev *snapshot, iss []issue) map[int]*redmine.IdName {
+	changes := map[int]*redmine.IdName{}
+	if prev == nil {
+		return changes
+	}
+	prevAssignees := map[int]*redmine.IdName{}
+	for _, si := range prev.Issues {
+		prevAssignees[si.ID] = si.AssignedTo
+	}
+	for _, is := range iss {
+		prevAssignee, ok := prevAssignees[is.ID]
+		if !ok {
+			continue
+		}
+		if assigneeID(prevAssignee) != assigneeID(is.AssignedTo) {
+			changes[is.ID] = prevAssignee
+		}
+	}
+	return changes
+}
+
+func assigneeID(idname *redmine.IdName) int {
+	if idname == nil {
+		return 0
+	}
+	return idname.Id
+}
+
+// postDigest posts the summary of the movement of issues in this week,
+// from the snapshots taken in last 7 days.
+func postDigest(ctx context.Context, opts options, poster messagePoster) error {
+	if opts.SnapshotFile == "" {
+		return errors.New("snapshot-file is required for digest")
+	}
+	snaps, err := loadSnapshots(opts.SnapshotFile)
+	if err != nil {
+		return err
+	}
+	since := today.Add(-6 * 24 * time.Hour).Format(snapshotDateLayout)
+	var week []snapshot
+	for _, snap := range snaps {
+		if snap.Date >= since {
+			week = append(week, openSnapshot(snap, finishedStatuses))
+		}
+	}
+	if len(week) == 0 {
+		return errors.New("no snapshots in this week")
+	}
+
+	first, last := week[0], week[len(week)-1]
+	expired := map[int]bool{}
+	for _, si := range first.Issues {
+		expired[si.ID] = si.Expired
+	}
+	var wentExpired []snapshotIssue
+	for _, snap := range week[1:] {
+		for _, si := range snap.Issues {
+			if si.Expired && !expired[si.ID] {
+				wentExpired = append(wentExpired, si)
+			}
+			expired[si.ID] = expired[si.ID] || si.Expired
+		}
+	}
+	stillOpen := map[int]bool{}
+	for _, si := range last.Issues {
+		stillOpen[si.ID] = true
+	}
+	var resolved []snapshotIssue
+	for _, si := range first.Issues {
+		if !stillOpen[si.ID] {
+			resolved = append(resolved, si)
+		}
+	}
+
+	var out bytes.Buffer
+	fmt.Fprintf(&out, messages.DigestHead, targetProject.Name, first.Date, last.Date)
+	fmt.Fprintf(&out, messages.DigestExpired, len(wentExpired))
+	for _, si := range wentExpired {
+		fmt.Fprintf(&out, "- %s: %s\n", issueLink(opts, renderState{}, si.ID), si.Subject)
+	}
+	fmt.Fprintf(&out, messages.DigestResolved, len(resolved))
+	for _, si := range resolved {
+		fmt.Fprintf(&out, "- %s: %s\n", issueLink(opts, renderState{}, si.ID), si.Subject)
+	}
+	fe, le := countExpired(first), countExpired(last)
+	fmt.Fprintf(&out, messages.DigestExpiredChange, fe, le, le-fe)
+
+	infof("post digest to slack")
+	return postText(ctx, opts, poster, out.String())
+}
+
+// openSnapshot returns the snapshot without issues in finished status.
+func openSnapshot(snap snapshot, finishedStatus []int) snapshot {
+	var sis []snapshotIssue
+	for _, si := range snap.Issues {
+		if !in(si.StatusID, finishedStatus) {
+			sis = append(sis, si)
+		}
+	}
+	snap.Issues = sis
+	return snap
+}
+
+// diffReopened returns the issues which were in finished status when they were last recorded in the snapshots.
+// Closed issues are not recorded after they are closed, so all the past snapshots are looked back.
+func diffReopened(past []snapshot, iss []issue, finishedStatus []int) []issue {
+	finished := map[int]bool{}
+	for _, snap := range past {
+		for _, si := range snap.Issues {
+			finished[si.ID] = in(si.StatusID, finishedStatus)
+		}
+	}
+	var reopened []issue
+	for _, is := range iss {
+		if finished[is.ID] && !in(is.StatusID, finishedStatus) {
+			reopened = append(reopened, is)
+		}
+	}
+	return reopened
+}
+
+func countExpired(snap snapshot) int {
+	var n int
+	for _, si := range snap.Issues {
+		if si.Expired {
+			n++
+		}
+	}
+	return n
+}
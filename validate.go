@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+var errValidationFailed = errors.New("validation failed")
+
+// validateCheck is an item of the preflight checklist.
+type validateCheck struct {
+	Name string
+	Run  func() error
+}
+
+// validate checks that Redmine and Slack are reachable with the given options
+// and prints the checklist, without posting any report.
+func validate(ctx context.Context, opts options, fetcher issueFetcher, poster messagePoster) error {
+	// Redmine itself is checked, not the cache
+	opts.Redmine.NoCache = true
+	checks := []validateCheck{
+		{"Redmine endpoint and API key", func() error {
+			_, err := fetcher.FetchStatuses(ctx, opts.Redmine)
+			return err
+		}},
+	}
+	for _, target := range splitList(opts.Redmine.Project) {
+		target := target
+		checks = append(checks, validateCheck{"Redmine project " + target, func() error {
+			_, err := getProject(ctx, fetcher, opts.Redmine, target)
+			return err
+		}})
+	}
+	if len(opts.Redmine.FinishedStatus) > 0 {
+		checks = append(checks, validateCheck{"Redmine finished statuses", func() error {
+			// statuses not found are errors in the checklist
+			opts.Redmine.StrictFinishedStatus = true
+			return loadFinishedStatuses(ctx, fetcher, opts.Redmine)
+		}})
+	}
+	if opts.Redmine.Scope != scopeMine && opts.Redmine.Project == "" {
+		checks = append(checks, validateCheck{"Redmine project", func() error {
+			return errors.New("redmine-project is required")
+		}})
+	}
+	if opts.Slack.WebhookURL != "" {
+		// webhook cannot be checked without posting
+		fmt.Println("[--] Slack webhook URL is not checked")
+	} else {
+		checks = append(checks, validateCheck{"Slack token", func() error {
+			return poster.Test(ctx)
+		}})
+		for _, channel := range splitList(opts.Slack.Channel) {
+			channel := channel
+			checks = append(checks, validateCheck{"Slack channel " + channel, func() error {
+				return checkPostable(ctx, poster, channel)
+			}})
+		}
+	}
+
+	failed := false
+	for _, c := range checks {
+		if err := c.Run(); err != nil {
+			failed = true
+			fmt.Printf("[NG] %s: %s\n", c.Name, err)
+			continue
+		}
+		fmt.Printf("[OK] %s\n", c.Name)
+	}
+	if failed {
+		return errValidationFailed
+	}
+	return nil
+}
+
+// checkPostable confirms the bot can post to the channel given by its ID or name,
+// that is, the bot is a member of it or it is a public channel the bot can join.
+func checkPostable(ctx context.Context, poster messagePoster, channel string) error {
+	channels, err := poster.ListChannels(ctx, "public_channel,private_channel")
+	if err != nil {
+		return err
+	}
+	c, ok := findChannel(channels, channel)
+	if !ok {
+		return errors.New("channel not found")
+	}
+	if !c.IsMember && c.IsPrivate {
+		return errors.New("not in channel: invite the bot to the channel by /invite")
+	}
+	return nil
+}